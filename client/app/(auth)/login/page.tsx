@@ -1,6 +1,6 @@
 'use client'
 
-import { useState } from 'react'
+import { useEffect, useState } from 'react'
 import { useRouter } from 'next/navigation'
 import Link from 'next/link'
 import { Button } from '@/components/ui/button'
@@ -16,10 +16,27 @@ export default function LoginPage() {
   const [password, setPassword] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
+  const [unverified, setUnverified] = useState(false)
+  const [resent, setResent] = useState(false)
+  const [mfaToken, setMfaToken] = useState('')
+  const [code, setCode] = useState('')
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false)
+
+  // Google sign-in hands accounts with two-factor authentication back here
+  // with the pending login in the URL fragment
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.hash.slice(1)).get('mfaToken')
+    if (token) {
+      setMfaToken(token)
+      window.history.replaceState(null, '', window.location.pathname)
+    }
+  }, [])
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     setError('')
+    setUnverified(false)
+    setResent(false)
     setLoading(true)
 
     try {
@@ -34,10 +51,57 @@ export default function LoginPage() {
 
       const data = await response.json()
 
+      // The password was right, but the email still needs verifying
+      if (response.status === 403 && data.code === 'email_not_verified') {
+        setUnverified(true)
+      }
+
       if (!response.ok) {
         throw new Error(data.error || 'Login failed')
       }
 
+      // The account has two-factor authentication, so ask for a code next
+      if (data.data?.mfaRequired) {
+        setMfaToken(data.data.mfaToken)
+        return
+      }
+
+      // Redirect to chat
+      router.push('/chat')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Something went wrong')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleVerifyCode = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+    setLoading(true)
+
+    try {
+      const response = await fetch('http://localhost:8080/api/v1/auth/2fa/verify', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        credentials: 'include',
+        body: JSON.stringify(
+          useRecoveryCode ? { mfaToken, recoveryCode: code } : { mfaToken, code }
+        ),
+      })
+
+      const data = await response.json()
+
+      if (!response.ok) {
+        // The pending login expired, so start over from the password
+        if (response.status === 401 && data.error !== 'Invalid code') {
+          handleStartOver()
+        }
+        throw new Error(data.error || 'Verification failed')
+      }
+
       // Redirect to chat
       router.push('/chat')
     } catch (err) {
@@ -47,6 +111,38 @@ export default function LoginPage() {
     }
   }
 
+  const handleStartOver = () => {
+    setMfaToken('')
+    setCode('')
+    setUseRecoveryCode(false)
+  }
+
+  const handleResend = async () => {
+    setLoading(true)
+
+    try {
+      const response = await fetch('http://localhost:8080/api/v1/auth/resend-verification', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ email }),
+      })
+
+      if (!response.ok) {
+        const data = await response.json()
+        throw new Error(data.error || 'Failed to resend the verification email')
+      }
+
+      setResent(true)
+    } catch (err) {
+      setUnverified(false)
+      setError(err instanceof Error ? err.message : 'Something went wrong')
+    } finally {
+      setLoading(false)
+    }
+  }
+
   const handleGoogleLogin = async () => {
     try {
       const response = await fetch('http://localhost:8080/api/v1/auth/google', {
@@ -62,6 +158,72 @@ export default function LoginPage() {
     }
   }
 
+  if (mfaToken) {
+    return (
+      <div className="flex min-h-screen items-center justify-center bg-linear-to-br from-zinc-50 to-zinc-100 dark:from-zinc-950 dark:to-zinc-900 p-4">
+        <Card className="w-full max-w-md">
+          <CardHeader className="space-y-1">
+            <CardTitle className="text-2xl font-bold">Two-factor authentication</CardTitle>
+            <CardDescription>
+              {useRecoveryCode
+                ? 'Enter one of your recovery codes'
+                : 'Enter the 6-digit code from your authenticator app'}
+            </CardDescription>
+          </CardHeader>
+          <CardContent>
+            <form onSubmit={handleVerifyCode} className="space-y-4">
+              {error && (
+                <Alert variant="destructive">
+                  <AlertDescription>{error}</AlertDescription>
+                </Alert>
+              )}
+
+              <div className="space-y-2">
+                <Label htmlFor="code">{useRecoveryCode ? 'Recovery code' : 'Code'}</Label>
+                <Input
+                  id="code"
+                  type="text"
+                  inputMode={useRecoveryCode ? 'text' : 'numeric'}
+                  autoComplete="one-time-code"
+                  placeholder={useRecoveryCode ? 'xxxxx-xxxxx' : '123456'}
+                  value={code}
+                  onChange={(e: React.ChangeEvent<HTMLInputElement>) => setCode(e.target.value)}
+                  required
+                  autoFocus
+                  disabled={loading}
+                />
+              </div>
+
+              <Button type="submit" className="w-full" disabled={loading}>
+                {loading && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}
+                Verify
+              </Button>
+            </form>
+
+            <Button
+              type="button"
+              variant="link"
+              className="mt-2 w-full"
+              onClick={() => {
+                setUseRecoveryCode(!useRecoveryCode)
+                setCode('')
+                setError('')
+              }}
+              disabled={loading}
+            >
+              {useRecoveryCode ? 'Use your authenticator app instead' : 'Use a recovery code instead'}
+            </Button>
+          </CardContent>
+          <CardFooter className="flex justify-center">
+            <Button type="button" variant="link" className="h-auto p-0 text-sm" onClick={handleStartOver} disabled={loading}>
+              Back to sign in
+            </Button>
+          </CardFooter>
+        </Card>
+      </div>
+    )
+  }
+
   return (
     <div className="flex min-h-screen items-center justify-center bg-linear-to-br from-zinc-50 to-zinc-100 dark:from-zinc-950 dark:to-zinc-900 p-4">
       <Card className="w-full max-w-md">
@@ -73,7 +235,20 @@ export default function LoginPage() {
           <form onSubmit={handleSubmit} className="space-y-4">
             {error && (
               <Alert variant="destructive">
-                <AlertDescription>{error}</AlertDescription>
+                <AlertDescription>
+                  {error}
+                  {unverified && (
+                    <Button
+                      type="button"
+                      variant="link"
+                      className="h-auto p-0"
+                      onClick={handleResend}
+                      disabled={loading || resent}
+                    >
+                      {resent ? 'Verification email sent' : 'Resend verification email'}
+                    </Button>
+                  )}
+                </AlertDescription>
               </Alert>
             )}
 
@@ -91,7 +266,12 @@ export default function LoginPage() {
             </div>
 
             <div className="space-y-2">
-              <Label htmlFor="password">Password</Label>
+              <div className="flex items-center justify-between">
+                <Label htmlFor="password">Password</Label>
+                <Link href="/forgot-password" className="text-sm text-muted-foreground hover:underline">
+                  Forgot password?
+                </Link>
+              </div>
               <Input
                 id="password"
                 type="password"
@@ -0,0 +1,80 @@
+'use client'
+
+import { Suspense, useEffect, useRef, useState } from 'react'
+import { useSearchParams } from 'next/navigation'
+import Link from 'next/link'
+import { Card, CardDescription, CardFooter, CardHeader, CardTitle } from '@/components/ui/card'
+import { Loader2 } from 'lucide-react'
+
+function VerifyEmail() {
+  const searchParams = useSearchParams()
+  const token = searchParams.get('token')
+  const [status, setStatus] = useState<'verifying' | 'verified' | 'failed'>(token ? 'verifying' : 'failed')
+  const [message, setMessage] = useState(token ? '' : 'The verification link is missing its token')
+  const requested = useRef(false)
+
+  useEffect(() => {
+    // Tokens work once, so never send the same one twice
+    if (!token || requested.current) {
+      return
+    }
+    requested.current = true
+
+    const verify = async () => {
+      try {
+        const response = await fetch('http://localhost:8080/api/v1/auth/verify-email', {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+          },
+          body: JSON.stringify({ token }),
+        })
+
+        const data = await response.json()
+
+        if (!response.ok) {
+          throw new Error(data.error || 'Verification failed')
+        }
+
+        setStatus('verified')
+      } catch (err) {
+        setStatus('failed')
+        setMessage(err instanceof Error ? err.message : 'Something went wrong')
+      }
+    }
+
+    verify()
+  }, [token])
+
+  return (
+    <Card className="w-full max-w-md">
+      <CardHeader className="space-y-1">
+        <CardTitle className="text-2xl font-bold">
+          {status === 'verifying' && 'Verifying your email'}
+          {status === 'verified' && 'Email verified'}
+          {status === 'failed' && 'Verification failed'}
+        </CardTitle>
+        <CardDescription>
+          {status === 'verifying' && <Loader2 className="h-4 w-4 animate-spin" />}
+          {status === 'verified' && 'Your account is ready. Sign in to start chatting.'}
+          {status === 'failed' && message}
+        </CardDescription>
+      </CardHeader>
+      <CardFooter className="flex justify-center">
+        <Link href="/login" className="text-sm font-medium text-primary hover:underline">
+          Go to sign in
+        </Link>
+      </CardFooter>
+    </Card>
+  )
+}
+
+export default function VerifyEmailPage() {
+  return (
+    <div className="flex min-h-screen items-center justify-center bg-linear-to-br from-zinc-50 to-zinc-100 dark:from-zinc-950 dark:to-zinc-900 p-4">
+      <Suspense fallback={<Loader2 className="h-6 w-6 animate-spin" />}>
+        <VerifyEmail />
+      </Suspense>
+    </div>
+  )
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is the shared Redis client (nil when REDIS_URL is not set)
+var Redis *redis.Client
+
+// ConnectRedis connects to the Redis server at REDIS_URL
+func ConnectRedis() error {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return fmt.Errorf("REDIS_URL environment variable is not set")
+	}
+
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	Redis = client
+	log.Println("✅ Redis connected successfully")
+	return nil
+}
+
+// CloseRedis closes the Redis client
+func CloseRedis() {
+	if Redis != nil {
+		Redis.Close()
+	}
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/mailer"
+	"ngabarin/server/internal/models"
+	"ngabarin/server/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// emailVerificationTTL is how long an email verification link works
+	emailVerificationTTL = 24 * time.Hour
+
+	// passwordResetTTL is how long a password reset link works
+	passwordResetTTL = time.Hour
+
+	// Purposes of emailed tokens
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposeResetPassword = "reset_password"
+)
+
+// EmailRequest represents a request naming an account by email
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmailRequest represents verify email request body
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResetPasswordRequest represents reset password request body
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// VerifyEmail marks the account's email verified with the token from the verification email
+func VerifyEmail(c *fiber.Ctx) error {
+	var req VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Token is required",
+		})
+	}
+
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := consumeUserToken(tx, tokenPurposeVerifyEmail, req.Token)
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid or expired verification link",
+		})
+	}
+
+	if err == nil {
+		_, err = tx.Exec(context.Background(), `
+			UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1), updated_at = $1 WHERE id = $2
+		`, time.Now(), userID)
+	}
+
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to verify email",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification emails a new verification link to an unverified account.
+// The response is the same whether or not the account exists.
+func ResendVerification(c *fiber.Ctx) error {
+	var req EmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Email is required",
+		})
+	}
+
+	var userID, name string
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT id, name FROM users WHERE email = $1 AND email_verified_at IS NULL
+	`, req.Email).Scan(&userID, &name)
+
+	if err == nil {
+		go sendVerificationEmail(userID, req.Email, name)
+	} else if err != pgx.ErrNoRows {
+		log.Printf("Failed to look up %s for verification: %v", req.Email, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "If the account needs verifying, a new link is on its way",
+	})
+}
+
+// ForgotPassword emails a password reset link to an email account.
+// The response is the same whether or not the account exists.
+func ForgotPassword(c *fiber.Ctx) error {
+	var req EmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Email is required",
+		})
+	}
+
+	var userID, name string
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT id, name FROM users WHERE email = $1 AND auth_provider = 'email'
+	`, req.Email).Scan(&userID, &name)
+
+	if err == nil {
+		go sendPasswordResetEmail(userID, req.Email, name)
+	} else if err != pgx.ErrNoRows {
+		log.Printf("Failed to look up %s for password reset: %v", req.Email, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "If an account uses this email, a reset link is on its way",
+	})
+}
+
+// ResetPassword sets a new password with the token from the reset email and logs
+// the account out of every session
+func ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if req.Token == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Token and password are required",
+		})
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to hash password",
+		})
+	}
+
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	userID, err := consumeUserToken(tx, tokenPurposeResetPassword, req.Token)
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid or expired reset link",
+		})
+	}
+
+	// Following the emailed link also proves the address
+	if err == nil {
+		_, err = tx.Exec(context.Background(), `
+			UPDATE users
+			SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, $2), updated_at = $2
+			WHERE id = $3
+		`, hashedPassword, time.Now(), userID)
+	}
+
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to reset password",
+		})
+	}
+
+	// Whoever knew the old password is logged out
+	if _, err := revokeSessions(userID, nil, ""); err != nil {
+		log.Printf("Failed to revoke sessions of %s after password reset: %v", userID, err)
+	}
+	recordSecurityEvent(c, userID, models.SecurityEventPasswordReset, "")
+
+	clearAuthCookies(c)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Password reset successfully, please log in",
+	})
+}
+
+// sendVerificationEmail emails a user a link to verify their address
+func sendVerificationEmail(userID, email, name string) {
+	token, err := createUserToken(userID, tokenPurposeVerifyEmail, emailVerificationTTL)
+	if err != nil {
+		log.Printf("Failed to create verification token for %s: %v", userID, err)
+		return
+	}
+
+	link := frontendURL() + "/verify-email?token=" + url.QueryEscape(token)
+	sendEmail(email, "Verify your Ngabarin email", fmt.Sprintf(
+		"Hi %s,\n\nConfirm your email address to start using Ngabarin:\n\n%s\n\nThe link expires in 24 hours.\n",
+		name, link))
+}
+
+// sendPasswordResetEmail emails a user a link to choose a new password
+func sendPasswordResetEmail(userID, email, name string) {
+	token, err := createUserToken(userID, tokenPurposeResetPassword, passwordResetTTL)
+	if err != nil {
+		log.Printf("Failed to create password reset token for %s: %v", userID, err)
+		return
+	}
+
+	link := frontendURL() + "/reset-password?token=" + url.QueryEscape(token)
+	sendEmail(email, "Reset your Ngabarin password", fmt.Sprintf(
+		"Hi %s,\n\nSomeone asked to reset your Ngabarin password. Choose a new one here:\n\n%s\n\n"+
+			"The link expires in 1 hour. If it wasn't you, ignore this email; your password stays the same.\n",
+		name, link))
+}
+
+// sendEmail sends an email, logging failures
+func sendEmail(to, subject, body string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := mailer.Send(ctx, mailer.Message{To: to, Subject: subject, Body: body}); err != nil {
+		log.Printf("Failed to send %q to %s: %v", subject, to, err)
+	}
+}
+
+// createUserToken issues a single-use token, replacing the user's unused ones for
+// the same purpose. Only its hash is stored.
+func createUserToken(userID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), `
+		DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)
+	`, userID, purpose, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit(context.Background())
+}
+
+// consumeUserToken marks a token used and returns its user. It returns pgx.ErrNoRows
+// for unknown, expired or already used tokens.
+func consumeUserToken(tx pgx.Tx, purpose, token string) (string, error) {
+	var userID string
+	err := tx.QueryRow(context.Background(), `
+		UPDATE user_tokens SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, time.Now(), hashToken(token), purpose).Scan(&userID)
+
+	return userID, err
+}
+
+// hashToken returns the stored form of an emailed token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// frontendURL returns the web app's base URL for links and redirects
+func frontendURL() string {
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"time"
 
 	"ngabarin/server/internal/database"
@@ -105,47 +106,15 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate JWT access token
-	token, err := utils.GenerateToken(user.ID, user.Email, user.UniqueID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to generate token",
-		})
-	}
-
-	// Generate refresh token
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email, user.UniqueID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to generate refresh token",
-		})
-	}
-
-	// Set HTTP-Only Cookie for access token
-	c.Cookie(&fiber.Cookie{
-		Name:     "token",
-		Value:    token,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   900, // 15 minutes
-	})
-
-	// Set HTTP-Only Cookie for refresh token
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   604800, // 7 days
-	})
+	// The account can log in once the address is confirmed
+	go sendVerificationEmail(user.ID, user.Email, user.Name)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
-		"data":    user.ToResponse(),
+		"data": fiber.Map{
+			"user":                      user.ToOwnResponse(),
+			"emailVerificationRequired": true,
+		},
 	})
 }
 
@@ -169,11 +138,14 @@ func Login(c *fiber.Ctx) error {
 
 	// Get user from database
 	var user models.User
+	var twoFactorEnabled, emailVerified bool
 	err := database.Pool.QueryRow(context.Background(), `
-		SELECT id, unique_id, email, name, password_hash, avatar, auth_provider, is_online, last_seen, created_at, updated_at
+		SELECT id, unique_id, email, name, password_hash, avatar, auth_provider, is_online, presence_status, status_text, status_expires_at, last_seen, created_at, updated_at,
+			totp_enabled_at IS NOT NULL, email_verified_at IS NOT NULL
 		FROM users WHERE email = $1
 	`, req.Email).Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Password,
-		&user.Avatar, &user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt)
+		&user.Avatar, &user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+		&twoFactorEnabled, &emailVerified)
 
 	if err == pgx.ErrNoRows {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -205,56 +177,59 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update user online status
-	_, err = database.Pool.Exec(context.Background(), "UPDATE users SET is_online = true, last_seen = $1 WHERE id = $2", time.Now(), user.ID)
-	if err != nil {
-		// Log error but don't fail the login
+	// Checked after the password so it doesn't reveal which emails are registered
+	if !emailVerified {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "Please verify your email before logging in",
+			"code":    "email_not_verified",
+		})
 	}
 
-	user.IsOnline = true
+	// With two-factor authentication, the session starts only after POST /auth/2fa/verify
+	if twoFactorEnabled {
+		mfaToken, err := utils.GenerateMFAToken(user.ID, user.Email, user.UniqueID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to generate token",
+			})
+		}
 
-	// Generate JWT access token
-	token, err := utils.GenerateToken(user.ID, user.Email, user.UniqueID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to generate token",
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data": fiber.Map{
+				"mfaRequired": true,
+				"mfaToken":    mfaToken,
+			},
 		})
 	}
 
-	// Generate refresh token
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email, user.UniqueID)
+	return completeLogin(c, &user)
+}
+
+// completeLogin marks the user online, starts a session for this device and returns the user
+func completeLogin(c *fiber.Ctx, user *models.User) error {
+	// Update user online status
+	_, err := database.Pool.Exec(context.Background(), "UPDATE users SET is_online = true, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = $2", time.Now(), user.ID)
 	if err != nil {
+		// Log error but don't fail the login
+	}
+
+	user.IsOnline = true
+
+	// Start a session for this device
+	if err := startSession(c, user); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to generate refresh token",
+			"error":   "Failed to create session",
 		})
 	}
 
-	// Set HTTP-Only Cookie for access token
-	c.Cookie(&fiber.Cookie{
-		Name:     "token",
-		Value:    token,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   900, // 15 minutes
-	})
-
-	// Set HTTP-Only Cookie for refresh token
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   604800, // 7 days
-	})
-
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data": fiber.Map{
-			"user": user.ToResponse(),
+			"user": user.ToOwnResponse(),
 		},
 	})
 }
@@ -265,10 +240,10 @@ func GetMe(c *fiber.Ctx) error {
 
 	var user models.User
 	err := database.Pool.QueryRow(context.Background(), `
-		SELECT id, unique_id, email, name, avatar, auth_provider, is_online, last_seen, created_at, updated_at
+		SELECT id, unique_id, email, name, avatar, auth_provider, is_online, presence_status, status_text, status_expires_at, last_seen, created_at, updated_at
 		FROM users WHERE id = $1
 	`, userID).Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-		&user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt)
+		&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == pgx.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -286,7 +261,7 @@ func GetMe(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"data":    user.ToResponse(),
+		"data":    user.ToOwnResponse(),
 	})
 }
 
@@ -295,30 +270,17 @@ func Logout(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 
 	// Update user offline status
-	_, err := database.Pool.Exec(context.Background(), "UPDATE users SET is_online = false, last_seen = $1 WHERE id = $2", time.Now(), userID)
+	_, err := database.Pool.Exec(context.Background(), "UPDATE users SET is_online = false, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = $2", time.Now(), userID)
 	if err != nil {
 		// Log error but don't fail the logout
 	}
 
-	// Clear access token cookie
-	c.Cookie(&fiber.Cookie{
-		Name:     "token",
-		Value:    "",
-		HTTPOnly: true,
-		Secure:   false,
-		SameSite: "Lax",
-		MaxAge:   -1, // Delete cookie
-	})
+	// End this device's session so its tokens stop working
+	if _, err := revokeSessions(userID, []string{c.Locals("sessionID").(string)}, ""); err != nil {
+		log.Printf("Failed to revoke session of %s: %v", userID, err)
+	}
 
-	// Clear refresh token cookie
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    "",
-		HTTPOnly: true,
-		Secure:   false,
-		SameSite: "Lax",
-		MaxAge:   -1, // Delete cookie
-	})
+	clearAuthCookies(c)
 
 	return c.JSON(fiber.Map{
 		"success": true,
@@ -354,43 +316,118 @@ func RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate new access token
-	newAccessToken, err := utils.GenerateToken(claims.UserID, claims.Email, claims.UniqueID)
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	// Lock the presented token so concurrent refreshes with it are handled one at a time
+	var usedAt *time.Time
+	err = tx.QueryRow(context.Background(), `
+		SELECT used_at FROM refresh_tokens
+		WHERE id::text = $1 AND session_id::text = $2
+		FOR UPDATE
+	`, claims.ID, claims.SessionID).Scan(&usedAt)
+
+	if err == pgx.ErrNoRows {
+		clearAuthCookies(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid refresh token",
+		})
+	}
+
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to generate access token",
+			"error":   "Database error",
+		})
+	}
+
+	if usedAt != nil {
+		// Another request rotated it a moment ago; the client should use the new cookie
+		if time.Since(*usedAt) < refreshReuseGrace {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success": false,
+				"error":   "Refresh token was already rotated",
+			})
+		}
+
+		// A rotated token came back, so it was copied: end the whole family
+		tx.Rollback(context.Background())
+		if _, err := revokeSessions(claims.UserID, []string{claims.SessionID}, ""); err != nil {
+			log.Printf("Failed to revoke session %s after refresh token reuse: %v", claims.SessionID, err)
+		}
+		recordSecurityEvent(c, claims.UserID, models.SecurityEventRefreshTokenReuse, claims.SessionID)
+
+		clearAuthCookies(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Refresh token reuse detected, please log in again",
 		})
 	}
 
-	// Generate new refresh token
-	newRefreshToken, err := utils.GenerateRefreshToken(claims.UserID, claims.Email, claims.UniqueID)
+	// The session must still be active; refreshing keeps it alive for another refresh window
+	now := time.Now()
+	tag, err := tx.Exec(context.Background(), `
+		UPDATE sessions SET last_active_at = $1, expires_at = $2, ip_address = $3
+		WHERE id::text = $4 AND user_id = $5 AND revoked_at IS NULL AND expires_at > $1
+	`, now, now.Add(utils.RefreshTokenTTL), c.IP(), claims.SessionID, claims.UserID)
+
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to generate refresh token",
+			"error":   "Database error",
 		})
 	}
 
-	// Set HTTP-Only Cookie for new access token
-	c.Cookie(&fiber.Cookie{
-		Name:     "token",
-		Value:    newAccessToken,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   900, // 15 minutes
-	})
+	if tag.RowsAffected() == 0 {
+		clearAuthCookies(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Session expired or revoked",
+		})
+	}
 
-	// Set HTTP-Only Cookie for new refresh token
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    newRefreshToken,
-		HTTPOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: "Lax",
-		MaxAge:   604800, // 7 days
-	})
+	// Rotate: the presented token is spent, and expired ones no longer need tracking
+	_, err = tx.Exec(context.Background(), `
+		UPDATE refresh_tokens SET used_at = $1 WHERE id::text = $2
+	`, now, claims.ID)
+
+	if err == nil {
+		_, err = tx.Exec(context.Background(), `
+			DELETE FROM refresh_tokens WHERE session_id::text = $1 AND expires_at < $2
+		`, claims.SessionID, now)
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	// Generate new tokens for the same session
+	newAccessToken, newRefreshToken, err := createTokens(tx, claims.UserID, claims.Email, claims.UniqueID, claims.SessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate tokens",
+		})
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to refresh tokens",
+		})
+	}
+
+	setAuthCookies(c, newAccessToken, newRefreshToken)
 
 	return c.JSON(fiber.Map{
 		"success": true,
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// BlockUserRequest represents block user request body
+type BlockUserRequest struct {
+	UserID string `json:"userId"`
+}
+
+// BlockedUser represents a user on the current user's block list
+type BlockedUser struct {
+	User      models.UserResponse `json:"user"`
+	BlockedAt time.Time           `json:"blockedAt"`
+}
+
+// BlockUser adds a user to the current user's block list
+func BlockUser(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req BlockUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid user ID",
+		})
+	}
+
+	if req.UserID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "You cannot block yourself",
+		})
+	}
+
+	// Check if user exists
+	var exists bool
+	err := database.Pool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", req.UserID).Scan(&exists)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "User not found",
+		})
+	}
+
+	_, err = database.Pool.Exec(context.Background(), `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+	`, userID, req.UserID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to block user",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "User blocked successfully",
+	})
+}
+
+// UnblockUser removes a user from the current user's block list
+func UnblockUser(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	blockedID := c.Params("userId")
+
+	if _, err := uuid.Parse(blockedID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid user ID",
+		})
+	}
+
+	result, err := database.Pool.Exec(context.Background(), `
+		DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2
+	`, userID, blockedID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to unblock user",
+		})
+	}
+
+	if result.RowsAffected() == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "User is not blocked",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "User unblocked successfully",
+	})
+}
+
+// GetBlockedUsers returns the current user's block list
+func GetBlockedUsers(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.presence_status, u.status_text, u.status_expires_at, u.last_seen, u.created_at, u.updated_at,
+			b.created_at
+		FROM user_blocks b
+		INNER JOIN users u ON b.blocked_id = u.id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`, userID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	var blocked []BlockedUser
+	var users []models.User
+
+	for rows.Next() {
+		var user models.User
+		var blockedAt time.Time
+
+		err := rows.Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
+			&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+			&blockedAt)
+
+		if err != nil {
+			continue
+		}
+
+		blocked = append(blocked, BlockedUser{BlockedAt: blockedAt})
+		users = append(users, user)
+	}
+
+	if blocked == nil {
+		blocked = []BlockedUser{}
+	}
+
+	for i, user := range userResponses(userID, users) {
+		blocked[i].User = user
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    blocked,
+	})
+}
@@ -21,6 +21,7 @@ type ChatListItem struct {
 	LastMessage *struct {
 		Content   string `json:"content"`
 		CreatedAt string `json:"createdAt"`
+		IsDeleted bool   `json:"isDeleted"`
 	} `json:"lastMessage,omitempty"`
 }
 
@@ -36,13 +37,15 @@ func GetChats(c *fiber.Ctx) error {
 				c.contact_id as user_id,
 				m.created_at as last_message_at,
 				m.content as last_message_content,
+				m.deleted_at IS NOT NULL as last_message_deleted,
 				TRUE as is_contact
 			FROM contacts c
 			LEFT JOIN LATERAL (
-				SELECT created_at, content
+				SELECT created_at, content, deleted_at
 				FROM messages
-				WHERE (sender_id = $1 AND receiver_id = c.contact_id)
-				   OR (sender_id = c.contact_id AND receiver_id = $1)
+				WHERE ((sender_id = $1 AND receiver_id = c.contact_id)
+				   OR (sender_id = c.contact_id AND receiver_id = $1))
+				  AND NOT EXISTS(SELECT 1 FROM hidden_messages h WHERE h.message_id = messages.id AND h.user_id = $1)
 				ORDER BY created_at DESC
 				LIMIT 1
 			) m ON TRUE
@@ -55,6 +58,7 @@ func GetChats(c *fiber.Ctx) error {
 				msg.user_id,
 				msg.last_message_at,
 				msg.last_message_content,
+				msg.last_message_deleted,
 				FALSE as is_contact
 			FROM (
 				SELECT DISTINCT ON (
@@ -68,9 +72,11 @@ func GetChats(c *fiber.Ctx) error {
 						ELSE sender_id 
 					END as user_id,
 					created_at as last_message_at,
-					content as last_message_content
+					content as last_message_content,
+					deleted_at IS NOT NULL as last_message_deleted
 				FROM messages
-				WHERE sender_id = $1 OR receiver_id = $1
+				WHERE (sender_id = $1 OR receiver_id = $1)
+				  AND NOT EXISTS(SELECT 1 FROM hidden_messages h WHERE h.message_id = messages.id AND h.user_id = $1)
 				ORDER BY 
 					CASE 
 						WHEN sender_id = $1 THEN receiver_id 
@@ -84,10 +90,11 @@ func GetChats(c *fiber.Ctx) error {
 		)
 		SELECT 
 			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, 
-			u.is_online, u.last_seen, u.created_at, u.updated_at,
+			u.is_online, u.presence_status, u.status_text, u.status_expires_at, u.last_seen, u.created_at, u.updated_at,
 			cl.is_contact,
 			cl.last_message_at,
-			cl.last_message_content
+			cl.last_message_content,
+			cl.last_message_deleted
 		FROM chat_list cl
 		INNER JOIN users u ON cl.user_id = u.id
 		ORDER BY cl.last_message_at DESC NULLS LAST
@@ -103,17 +110,19 @@ func GetChats(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	var chats []ChatListItem
+	var users []models.User
 
 	for rows.Next() {
 		var user models.User
 		var isContact bool
 		var lastMessageAt *time.Time
 		var lastMessageContent *string
+		var lastMessageDeleted *bool
 
 		err := rows.Scan(
 			&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-			&user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
-			&isContact, &lastMessageAt, &lastMessageContent,
+			&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+			&isContact, &lastMessageAt, &lastMessageContent, &lastMessageDeleted,
 		)
 
 		if err != nil {
@@ -122,9 +131,7 @@ func GetChats(c *fiber.Ctx) error {
 
 		chatItem := ChatListItem{
 			ID:        user.ID,
-			User:      user.ToResponse(),
 			IsContact: isContact,
-			IsOnline:  user.IsOnline,
 		}
 
 		// Add last message if exists
@@ -132,19 +139,38 @@ func GetChats(c *fiber.Ctx) error {
 			chatItem.LastMessage = &struct {
 				Content   string `json:"content"`
 				CreatedAt string `json:"createdAt"`
+				IsDeleted bool   `json:"isDeleted"`
 			}{
 				Content:   *lastMessageContent,
 				CreatedAt: lastMessageAt.Format(time.RFC3339),
+				IsDeleted: lastMessageDeleted != nil && *lastMessageDeleted,
 			}
 		}
 
 		chats = append(chats, chatItem)
+		users = append(users, user)
 	}
 
 	if chats == nil {
 		chats = []ChatListItem{}
 	}
 
+	// Use live presence for the online dot
+	userIDs := make([]string, len(chats))
+	for i, user := range userResponses(userID, users) {
+		chats[i].User = user
+		userIDs[i] = user.ID
+	}
+	if online := onlineStatuses(userIDs); online != nil {
+		for i := range chats {
+			chats[i].User.SetOnline(online[chats[i].User.ID])
+		}
+	}
+
+	for i := range chats {
+		chats[i].IsOnline = chats[i].User.IsOnline
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    chats,
@@ -57,11 +57,11 @@ func AddContact(c *fiber.Ctx) error {
 	// Find user by unique ID
 	var contactUser models.User
 	err = database.Pool.QueryRow(context.Background(), `
-		SELECT id, unique_id, email, name, avatar, auth_provider, is_online, last_seen, created_at, updated_at
+		SELECT id, unique_id, email, name, avatar, auth_provider, is_online, presence_status, status_text, status_expires_at, last_seen, created_at, updated_at
 		FROM users WHERE unique_id = $1
 	`, req.UniqueID).Scan(&contactUser.ID, &contactUser.UniqueID, &contactUser.Email,
 		&contactUser.Name, &contactUser.Avatar, &contactUser.AuthProvider,
-		&contactUser.IsOnline, &contactUser.LastSeen, &contactUser.CreatedAt, &contactUser.UpdatedAt)
+		&contactUser.IsOnline, &contactUser.PresenceStatus, &contactUser.StatusText, &contactUser.StatusExpiresAt, &contactUser.LastSeen, &contactUser.CreatedAt, &contactUser.UpdatedAt)
 
 	if err == pgx.ErrNoRows {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -113,14 +113,15 @@ func AddContact(c *fiber.Ctx) error {
 	}
 
 	// Return contact with user info
+	contactResponse := userResponse(userID, contactUser)
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
 		"data": models.ContactWithUser{
 			ID:       contact.ID,
 			UserID:   contact.UserID,
-			Contact:  contactUser.ToResponse(),
+			Contact:  contactResponse,
 			AddedAt:  contact.AddedAt,
-			IsOnline: contactUser.IsOnline,
+			IsOnline: contactResponse.IsOnline,
 		},
 	})
 }
@@ -132,7 +133,7 @@ func GetContacts(c *fiber.Ctx) error {
 	rows, err := database.Pool.Query(context.Background(), `
 		SELECT 
 			c.id, c.user_id, c.added_at,
-			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.last_seen, u.created_at, u.updated_at
+			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.presence_status, u.status_text, u.status_expires_at, u.last_seen, u.created_at, u.updated_at
 		FROM contacts c
 		INNER JOIN users u ON c.contact_id = u.id
 		WHERE c.user_id = $1
@@ -148,6 +149,7 @@ func GetContacts(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	var contacts []models.ContactWithUser
+	var users []models.User
 
 	for rows.Next() {
 		var contact models.Contact
@@ -156,7 +158,7 @@ func GetContacts(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&contact.ID, &contact.UserID, &contact.AddedAt,
 			&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-			&user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+			&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
 		)
 
 		if err != nil {
@@ -164,18 +166,19 @@ func GetContacts(c *fiber.Ctx) error {
 		}
 
 		contacts = append(contacts, models.ContactWithUser{
-			ID:       contact.ID,
-			UserID:   contact.UserID,
-			Contact:  user.ToResponse(),
-			AddedAt:  contact.AddedAt,
-			IsOnline: user.IsOnline,
+			ID:      contact.ID,
+			UserID:  contact.UserID,
+			AddedAt: contact.AddedAt,
 		})
+		users = append(users, user)
 	}
 
 	if contacts == nil {
 		contacts = []models.ContactWithUser{}
 	}
 
+	applyContactPresence(userID, contacts, users)
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    contacts,
@@ -197,7 +200,7 @@ func SearchContacts(c *fiber.Ctx) error {
 	rows, err := database.Pool.Query(context.Background(), `
 		SELECT 
 			c.id, c.user_id, c.added_at,
-			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.last_seen, u.created_at, u.updated_at
+			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.presence_status, u.status_text, u.status_expires_at, u.last_seen, u.created_at, u.updated_at
 		FROM contacts c
 		INNER JOIN users u ON c.contact_id = u.id
 		WHERE c.user_id = $1 
@@ -214,6 +217,7 @@ func SearchContacts(c *fiber.Ctx) error {
 	defer rows.Close()
 
 	var contacts []models.ContactWithUser
+	var users []models.User
 
 	for rows.Next() {
 		var contact models.Contact
@@ -222,7 +226,7 @@ func SearchContacts(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&contact.ID, &contact.UserID, &contact.AddedAt,
 			&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-			&user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+			&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
 		)
 
 		if err != nil {
@@ -230,18 +234,19 @@ func SearchContacts(c *fiber.Ctx) error {
 		}
 
 		contacts = append(contacts, models.ContactWithUser{
-			ID:       contact.ID,
-			UserID:   contact.UserID,
-			Contact:  user.ToResponse(),
-			AddedAt:  contact.AddedAt,
-			IsOnline: user.IsOnline,
+			ID:      contact.ID,
+			UserID:  contact.UserID,
+			AddedAt: contact.AddedAt,
 		})
+		users = append(users, user)
 	}
 
 	if contacts == nil {
 		contacts = []models.ContactWithUser{}
 	}
 
+	applyContactPresence(userID, contacts, users)
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    contacts,
@@ -294,3 +299,23 @@ func RemoveContact(c *fiber.Ctx) error {
 		"message": "Contact removed successfully",
 	})
 }
+
+// applyContactPresence sets each contact's profile, users[i], as the viewer sees it
+// and replaces the stored online status with their live presence
+func applyContactPresence(viewerID string, contacts []models.ContactWithUser, users []models.User) {
+	userIDs := make([]string, len(contacts))
+	for i, user := range userResponses(viewerID, users) {
+		contacts[i].Contact = user
+		userIDs[i] = user.ID
+	}
+
+	if online := onlineStatuses(userIDs); online != nil {
+		for i := range contacts {
+			contacts[i].Contact.SetOnline(online[contacts[i].Contact.ID])
+		}
+	}
+
+	for i := range contacts {
+		contacts[i].IsOnline = contacts[i].Contact.IsOnline
+	}
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	ws "ngabarin/server/internal/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// sseKeepAlive is how often an idle event stream sends a comment to keep proxies from closing it
+	sseKeepAlive = 25 * time.Second
+
+	// defaultPollTimeout is how long a poll waits for events when the client does not say
+	defaultPollTimeout = 25 * time.Second
+
+	// maxPollTimeout is the longest a poll may wait for events
+	maxPollTimeout = 55 * time.Second
+
+	// longPollSessionTTL is how long a long-poll session stays connected without a poll
+	longPollSessionTTL = 60 * time.Second
+)
+
+// longPollSession keeps a long-poll client registered with the hub between polls,
+// so the user does not flap offline and no events are missed between requests
+type longPollSession struct {
+	client   *ws.Client
+	mu       sync.Mutex // One poll at a time per session
+	lastPoll time.Time
+}
+
+var (
+	longPollSessions   = make(map[string]*longPollSession)
+	longPollSessionsMu sync.Mutex
+)
+
+// EventStream streams hub events as Server-Sent Events for clients that cannot use WebSockets
+func EventStream(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	uniqueID := c.Locals("uniqueID").(string)
+
+	if WSHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "WebSocket hub not initialized",
+		})
+	}
+
+	deviceID := c.Query("deviceId")
+	if deviceID == "" || len(deviceID) > MaxDeviceIDLength {
+		deviceID = uuid.New().String()
+	}
+
+	// Browsers resume an EventSource with the Last-Event-ID header
+	since := c.Query("since")
+	if lastEventID := c.Get("Last-Event-ID"); lastEventID != "" {
+		since = lastEventID
+	}
+
+	client := ws.NewStreamClient(userID, uniqueID, deviceID, ws.TransportSSE, WSHub)
+	client.SessionID = c.Locals("sessionID").(string)
+	prepareClient(client, since)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+
+	WSHub.Register <- client
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			WSHub.Unregister <- client
+		}()
+
+		// Send the headers right away
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			frames, closed := client.WaitFrames(sseKeepAlive)
+
+			if len(frames) == 0 && !closed {
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+
+			for _, frame := range frames {
+				if seq := ws.FrameSeq(frame); seq > 0 {
+					fmt.Fprintf(w, "id: %d\n", seq)
+				}
+				fmt.Fprintf(w, "data: %s\n\n", frame)
+			}
+
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil || closed {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// PollEvents returns queued hub events, waiting up to ?timeout= for the first one.
+// Pass the returned sessionId on the next poll to keep receiving events without gaps.
+func PollEvents(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	uniqueID := c.Locals("uniqueID").(string)
+
+	if WSHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "WebSocket hub not initialized",
+		})
+	}
+
+	timeout := defaultPollTimeout
+	if value := c.Query("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid timeout",
+			})
+		}
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+
+	session := getLongPollSession(c.Query("sessionId"), userID)
+	if session == nil {
+		// Start a new session, resuming from ?since= if given
+		sessionID := uuid.New().String()
+		client := ws.NewStreamClient(userID, uniqueID, sessionID, ws.TransportLongPoll, WSHub)
+		client.SessionID = c.Locals("sessionID").(string)
+		prepareClient(client, c.Query("since"))
+
+		session = &longPollSession{client: client, lastPoll: time.Now()}
+
+		longPollSessionsMu.Lock()
+		longPollSessions[sessionID] = session
+		longPollSessionsMu.Unlock()
+
+		WSHub.Register <- client
+	}
+
+	session.mu.Lock()
+	frames, closed := session.client.WaitFrames(timeout)
+	session.lastPoll = time.Now()
+	session.mu.Unlock()
+
+	// The hub dropped the session; the client should start over with ?since=
+	if closed {
+		closeLongPollSession(session.client.DeviceID)
+	}
+
+	events := make([]json.RawMessage, len(frames))
+	for i, frame := range frames {
+		events[i] = frame
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"sessionId": session.client.DeviceID,
+			"events":    events,
+			"closed":    closed,
+		},
+	})
+}
+
+// SendClientEvent accepts client-to-server events (typing, send_message, ...) over
+// REST for clients on the SSE or long-poll transports. Presence subscriptions
+// need ?deviceId= set to the stream's deviceId or the long-poll sessionId.
+func SendClientEvent(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	if WSHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "WebSocket hub not initialized",
+		})
+	}
+
+	var event ws.IncomingMessage
+	if err := c.BodyParser(&event); err != nil || event.Type == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	replies := WSHub.HandleEvent(userID, getUserName(userID), c.Query("deviceId"), event)
+
+	// Report error frames as HTTP errors
+	for _, reply := range replies {
+		if errPayload, ok := reply.Payload.(*ws.ErrorPayload); ok && reply.Type == ws.EventError {
+			return c.Status(eventErrorStatus(errPayload.Code)).JSON(fiber.Map{
+				"success": false,
+				"error":   errPayload.Message,
+				"code":    errPayload.Code,
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    replies,
+	})
+}
+
+// eventErrorStatus maps an error frame code to an HTTP status
+func eventErrorStatus(code string) int {
+	switch code {
+	case errCodeInvalidRequest:
+		return fiber.StatusBadRequest
+	case errCodeForbidden:
+		return fiber.StatusForbidden
+	case errCodeNotFound:
+		return fiber.StatusNotFound
+	case errCodeConflict:
+		return fiber.StatusConflict
+	case "unavailable":
+		return fiber.StatusServiceUnavailable
+	}
+	return fiber.StatusInternalServerError
+}
+
+// getLongPollSession returns the user's long-poll session with the given ID, if it exists
+func getLongPollSession(sessionID, userID string) *longPollSession {
+	if sessionID == "" {
+		return nil
+	}
+
+	longPollSessionsMu.Lock()
+	defer longPollSessionsMu.Unlock()
+
+	session, ok := longPollSessions[sessionID]
+	if !ok || session.client.ID != userID {
+		return nil
+	}
+
+	return session
+}
+
+// closeLongPollSession forgets a session and unregisters its client
+func closeLongPollSession(sessionID string) {
+	longPollSessionsMu.Lock()
+	session, ok := longPollSessions[sessionID]
+	delete(longPollSessions, sessionID)
+	longPollSessionsMu.Unlock()
+
+	if ok {
+		WSHub.Unregister <- session.client
+	}
+}
+
+// reapLongPollSessions closes sessions whose client stopped polling
+func reapLongPollSessions() {
+	ticker := time.NewTicker(longPollSessionTTL / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var expired []string
+
+		longPollSessionsMu.Lock()
+		for sessionID, session := range longPollSessions {
+			// A poll in progress holds the session lock
+			if !session.mu.TryLock() {
+				continue
+			}
+			if time.Since(session.lastPoll) > longPollSessionTTL {
+				expired = append(expired, sessionID)
+			}
+			session.mu.Unlock()
+		}
+		longPollSessionsMu.Unlock()
+
+		for _, sessionID := range expired {
+			closeLongPollSession(sessionID)
+		}
+	}
+}
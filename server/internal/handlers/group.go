@@ -26,6 +26,17 @@ type UpdateGroupRequest struct {
 	Icon string `json:"icon,omitempty"`
 }
 
+// SendGroupMessageRequest represents send group message request body
+type SendGroupMessageRequest struct {
+	GroupID   string `json:"groupId"`
+	Content   string `json:"content"`
+	Type      string `json:"type"`
+	ReplyToID string `json:"replyToId,omitempty"`
+
+	// ClientMessageID deduplicates retried sends (falls back to the Idempotency-Key header)
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
 // AddMembersRequest represents add members request body
 type AddMembersRequest struct {
 	UserIDs []string `json:"userIds"`
@@ -127,7 +138,7 @@ func CreateGroup(c *fiber.Ctx) error {
 	}
 
 	// Get members
-	members, _ := getGroupMembers(group.ID)
+	members, _ := getGroupMembers(group.ID, userID)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
@@ -214,19 +225,21 @@ func GetGroups(c *fiber.Ctx) error {
 		var lastMessage *fiber.Map
 		var msgContent, senderName string
 		var msgCreatedAt time.Time
+		var msgDeleted bool
 		err = database.Pool.QueryRow(context.Background(), `
-			SELECT m.content, u.name, m.created_at
+			SELECT m.content, u.name, m.created_at, m.deleted_at IS NOT NULL
 			FROM messages m
 			INNER JOIN users u ON m.sender_id = u.id
-			WHERE m.group_id = $1
+			WHERE m.group_id = $2 AND `+notHiddenCondition+`
 			ORDER BY m.created_at DESC
 			LIMIT 1
-		`, group.ID).Scan(&msgContent, &senderName, &msgCreatedAt)
+		`, userID, group.ID).Scan(&msgContent, &senderName, &msgCreatedAt, &msgDeleted)
 
 		if err == nil {
 			lastMessage = &fiber.Map{
 				"content":   msgContent,
 				"createdAt": msgCreatedAt,
+				"isDeleted": msgDeleted,
 				"sender": fiber.Map{
 					"name": senderName,
 				},
@@ -310,7 +323,7 @@ func GetGroupDetails(c *fiber.Ctx) error {
 	}
 
 	// Get members
-	members, err := getGroupMembers(groupID)
+	members, err := getGroupMembers(groupID, userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -332,10 +345,10 @@ func GetGroupDetails(c *fiber.Ctx) error {
 	})
 }
 
-// Helper function to get group members
-func getGroupMembers(groupID string) ([]models.UserResponse, error) {
+// Helper function to get group members as the viewer may see them
+func getGroupMembers(groupID, viewerID string) ([]models.UserResponse, error) {
 	rows, err := database.Pool.Query(context.Background(), `
-		SELECT u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.last_seen, u.created_at
+		SELECT u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.presence_status, u.status_text, u.status_expires_at, u.last_seen, u.created_at
 		FROM users u
 		INNER JOIN group_members gm ON u.id = gm.user_id
 		WHERE gm.group_id = $1
@@ -347,22 +360,31 @@ func getGroupMembers(groupID string) ([]models.UserResponse, error) {
 	}
 	defer rows.Close()
 
-	var members []models.UserResponse
+	var users []models.User
 
 	for rows.Next() {
 		var user models.User
 		err := rows.Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-			&user.AuthProvider, &user.IsOnline, &user.LastSeen, &user.CreatedAt)
+			&user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt)
 
 		if err != nil {
 			continue
 		}
 
-		members = append(members, user.ToResponse())
+		users = append(users, user)
 	}
 
-	if members == nil {
-		members = []models.UserResponse{}
+	members := userResponses(viewerID, users)
+
+	// Use live presence for the online dot
+	userIDs := make([]string, len(members))
+	for i := range members {
+		userIDs[i] = members[i].ID
+	}
+	if online := onlineStatuses(userIDs); online != nil {
+		for i := range members {
+			members[i].SetOnline(online[members[i].ID])
+		}
 	}
 
 	return members, nil
@@ -673,12 +695,7 @@ func DeleteGroup(c *fiber.Ctx) error {
 func SendGroupMessage(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 
-	var req struct {
-		GroupID string `json:"groupId"`
-		Content string `json:"content"`
-		Type    string `json:"type"`
-	}
-
+	var req SendGroupMessageRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
@@ -686,12 +703,50 @@ func SendGroupMessage(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.ClientMessageID == "" {
+		req.ClientMessageID = c.Get("Idempotency-Key")
+	}
+
+	message, created, err := createGroupMessage(userID, req)
+	if err != nil {
+		return respondRequestError(c, err)
+	}
+
+	// A retried request returns the originally created message
+	status := fiber.StatusCreated
+	if !created {
+		status = fiber.StatusOK
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// createGroupMessage validates and stores a group message, then notifies the group members.
+// If the sender already sent a message with the same client message ID, that message is
+// returned instead and created is false.
+func createGroupMessage(userID string, req SendGroupMessageRequest) (message *models.Message, created bool, err error) {
+	// Return the original message for a retried send
+	if existing, err := findMessageByClientID(userID, req.ClientMessageID); err != nil || existing != nil {
+		if err == nil && !isSameGroupTarget(existing, req.GroupID) {
+			err = errClientMessageIDReused
+		}
+		return existing, false, err
+	}
+
 	// Validate input
 	if req.GroupID == "" || req.Content == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"error":   "Group ID and content are required",
-		})
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Group ID and content are required"}
+	}
+
+	if len(req.ClientMessageID) > MaxClientMessageIDLength {
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Client message ID is too long"}
+	}
+
+	if req.ReplyToID != "" && !isUUID(req.ReplyToID) {
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Invalid reply message ID"}
 	}
 
 	if req.Type == "" {
@@ -700,46 +755,77 @@ func SendGroupMessage(c *fiber.Ctx) error {
 
 	// Check if user is member
 	var isMember bool
-	err := database.Pool.QueryRow(context.Background(), `
+	err = database.Pool.QueryRow(context.Background(), `
 		SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = $1 AND user_id = $2)
 	`, req.GroupID, userID).Scan(&isMember)
 
 	if err != nil || !isMember {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"success": false,
-			"error":   "You are not a member of this group",
-		})
+		return nil, false, &requestError{fiber.StatusForbidden, errCodeForbidden, "You are not a member of this group"}
+	}
+
+	// Check if the replied message belongs to this group
+	var replyToID *string
+	if req.ReplyToID != "" {
+		valid, err := isValidReplyTarget(req.ReplyToID, userID, "", req.GroupID)
+		if err != nil {
+			return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Database error"}
+		}
+
+		if !valid {
+			return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Replied message not found in this group"}
+		}
+		replyToID = &req.ReplyToID
+	}
+
+	var clientMessageID *string
+	if req.ClientMessageID != "" {
+		clientMessageID = &req.ClientMessageID
 	}
 
-	// Insert message
-	var message models.Message
+	// Insert message (a concurrent retry with the same client message ID inserts nothing)
+	message = &models.Message{}
 	err = database.Pool.QueryRow(context.Background(), `
-		INSERT INTO messages (sender_id, group_id, content, type, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, sender_id, group_id, content, type, status, created_at, updated_at
-	`, userID, req.GroupID, req.Content, req.Type, "sent", time.Now(), time.Now()).
+		INSERT INTO messages (sender_id, group_id, content, type, status, reply_to_id, client_message_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (sender_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+		RETURNING id, sender_id, group_id, content, type, status, reply_to_id, client_message_id, created_at, updated_at
+	`, userID, req.GroupID, req.Content, req.Type, "sent", replyToID, clientMessageID, time.Now(), time.Now()).
 		Scan(&message.ID, &message.SenderID, &message.GroupID, &message.Content,
-			&message.Type, &message.Status, &message.CreatedAt, &message.UpdatedAt)
+			&message.Type, &message.Status, &message.ReplyToID, &message.ClientMessageID, &message.CreatedAt, &message.UpdatedAt)
+
+	if err == pgx.ErrNoRows {
+		existing, err := findMessageByClientID(userID, req.ClientMessageID)
+		if err == nil && (existing == nil || !isSameGroupTarget(existing, req.GroupID)) {
+			err = errClientMessageIDReused
+		}
+		return existing, false, err
+	}
 
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to send message",
-		})
+		return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Failed to send message"}
 	}
 
 	// Broadcast message via WebSocket to all group members
 	if WSHub != nil {
+		payload := ws.GroupMessagePayload{
+			ID:        message.ID,
+			GroupID:   req.GroupID,
+			SenderID:  message.SenderID,
+			Content:   message.Content,
+			Type:      message.Type,
+			CreatedAt: message.CreatedAt,
+		}
+		if message.ClientMessageID != nil {
+			payload.ClientMessageID = *message.ClientMessageID
+		}
+		if message.ReplyToID != nil {
+			payload.ReplyToID = *message.ReplyToID
+			payload.ReplyTo, _ = getReplyPreview(*message.ReplyToID)
+		}
+
 		wsMessage := ws.WSMessage{
-			Type: ws.EventGroupMessageReceived,
-			Payload: ws.GroupMessagePayload{
-				ID:        message.ID,
-				GroupID:   req.GroupID,
-				SenderID:  message.SenderID,
-				Content:   message.Content,
-				Type:      message.Type,
-				CreatedAt: message.CreatedAt,
-			},
+			Type:      ws.EventGroupMessageReceived,
+			Payload:   payload,
 			Timestamp: time.Now(),
 		}
 		// Broadcast to all group members except sender
@@ -747,24 +833,14 @@ func SendGroupMessage(c *fiber.Ctx) error {
 
 		// Also send to sender for confirmation
 		confirmMessage := ws.WSMessage{
-			Type: ws.EventGroupMessageSent,
-			Payload: ws.GroupMessagePayload{
-				ID:        message.ID,
-				GroupID:   req.GroupID,
-				SenderID:  message.SenderID,
-				Content:   message.Content,
-				Type:      message.Type,
-				CreatedAt: message.CreatedAt,
-			},
+			Type:      ws.EventGroupMessageSent,
+			Payload:   payload,
 			Timestamp: time.Now(),
 		}
 		WSHub.BroadcastToUser(userID, confirmMessage)
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"success": true,
-		"data":    message,
-	})
+	return message, true, nil
 }
 
 // GetGroupMessages returns messages in a group
@@ -785,7 +861,20 @@ func GetGroupMessages(c *fiber.Ctx) error {
 		})
 	}
 
-	// Pagination
+	// Keyset pagination (before/after/around cursors)
+	pageReq, err := parseMessagePageRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid pagination parameters",
+		})
+	}
+
+	if pageReq != nil {
+		return respondMessagePage(c, userID, groupID, groupConversationCondition, pageReq)
+	}
+
+	// Legacy page/limit pagination
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
 	offset := (page - 1) * limit
@@ -793,8 +882,8 @@ func GetGroupMessages(c *fiber.Ctx) error {
 	// Get total count
 	var total int
 	err = database.Pool.QueryRow(context.Background(), `
-		SELECT COUNT(*) FROM messages WHERE group_id = $1
-	`, groupID).Scan(&total)
+		SELECT COUNT(*) FROM messages m WHERE `+groupConversationCondition+` AND `+notHiddenCondition+`
+	`, userID, groupID).Scan(&total)
 
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -805,15 +894,12 @@ func GetGroupMessages(c *fiber.Ctx) error {
 
 	// Get messages with sender info
 	rows, err := database.Pool.Query(context.Background(), `
-		SELECT 
-			m.id, m.sender_id, m.group_id, m.content, m.type, m.created_at,
-			u.id, u.unique_id, u.name, u.avatar
-		FROM messages m
-		INNER JOIN users u ON m.sender_id = u.id
-		WHERE m.group_id = $1
+		SELECT `+messageWithSenderColumns+`
+		FROM `+messageWithSenderJoins+`
+		WHERE `+groupConversationCondition+` AND `+notHiddenCondition+`
 		ORDER BY m.created_at DESC
-		LIMIT $2 OFFSET $3
-	`, groupID, limit, offset)
+		LIMIT $3 OFFSET $4
+	`, userID, groupID, limit, offset)
 
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -823,42 +909,30 @@ func GetGroupMessages(c *fiber.Ctx) error {
 	}
 	defer rows.Close()
 
-	var messages []fiber.Map
+	var messages []models.MessageWithSender
 
 	for rows.Next() {
-		var msgID, senderID, groupID, content, msgType string
-		var createdAt time.Time
-		var userID, uniqueID, name string
-		var avatar *string
-
-		err := rows.Scan(
-			&msgID, &senderID, &groupID, &content, &msgType, &createdAt,
-			&userID, &uniqueID, &name, &avatar,
-		)
-
+		message, err := scanMessageWithSender(rows)
 		if err != nil {
 			continue
 		}
 
-		messages = append(messages, fiber.Map{
-			"id":      msgID,
-			"groupId": groupID,
-			"content": content,
-			"type":    msgType,
-			"sender": fiber.Map{
-				"id":       userID,
-				"uniqueId": uniqueID,
-				"name":     name,
-				"avatar":   avatar,
-			},
-			"createdAt": createdAt,
-		})
+		messages = append(messages, message)
 	}
 
 	if messages == nil {
-		messages = []fiber.Map{}
+		messages = []models.MessageWithSender{}
+	}
+
+	if err := attachReactions(messages, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
 	}
 
+	applyMessagePrivacy(messages, userID)
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data": fiber.Map{
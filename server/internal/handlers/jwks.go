@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"log"
+
+	"ngabarin/server/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetJWKS serves the public keys access tokens are signed with, so other services can verify them
+func GetJWKS(c *fiber.Ctx) error {
+	jwks, err := utils.PublicJWKS()
+	if err != nil {
+		log.Printf("Failed to load JWT keys: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to load signing keys",
+		})
+	}
+
+	// Verifiers may cache the keys for a while; rotations keep the old key listed
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(jwks)
+}
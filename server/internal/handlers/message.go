@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"log"
+	"os"
 	"strconv"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	ws "ngabarin/server/internal/websocket"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
@@ -19,8 +21,15 @@ type SendMessageRequest struct {
 	ReceiverID string `json:"receiverId"`
 	Content    string `json:"content"`
 	Type       string `json:"type"` // text, image, file
+	ReplyToID  string `json:"replyToId,omitempty"`
+
+	// ClientMessageID deduplicates retried sends (falls back to the Idempotency-Key header)
+	ClientMessageID string `json:"clientMessageId,omitempty"`
 }
 
+// MaxClientMessageIDLength is the maximum length of a client message ID
+const MaxClientMessageIDLength = 100
+
 // MarkReadRequest represents mark as read request body
 type MarkReadRequest struct {
 	MessageIDs []string `json:"messageIds,omitempty"`
@@ -28,6 +37,41 @@ type MarkReadRequest struct {
 	ChatID     string   `json:"chatId,omitempty"`
 }
 
+// requestError is a validation or permission error shared by the REST and WebSocket send paths
+type requestError struct {
+	Status  int    // HTTP status code
+	Code    string // WebSocket error code
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+// Error codes reported to WebSocket clients
+const (
+	errCodeInvalidRequest = "invalid_request"
+	errCodeNotFound       = "not_found"
+	errCodeForbidden      = "forbidden"
+	errCodeConflict       = "conflict"
+	errCodeInternal       = "internal_error"
+)
+
+// respondRequestError writes the JSON response for an error returned by a shared send function
+func respondRequestError(c *fiber.Ctx, err error) error {
+	if reqErr, ok := err.(*requestError); ok {
+		return c.Status(reqErr.Status).JSON(fiber.Map{
+			"success": false,
+			"error":   reqErr.Message,
+		})
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"success": false,
+		"error":   "Failed to send message",
+	})
+}
+
 // SendMessage sends a direct message
 func SendMessage(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
@@ -40,12 +84,50 @@ func SendMessage(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.ClientMessageID == "" {
+		req.ClientMessageID = c.Get("Idempotency-Key")
+	}
+
+	message, created, err := createDirectMessage(userID, req)
+	if err != nil {
+		return respondRequestError(c, err)
+	}
+
+	// A retried request returns the originally created message
+	status := fiber.StatusCreated
+	if !created {
+		status = fiber.StatusOK
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// createDirectMessage validates and stores a direct message, then notifies the receiver.
+// If the sender already sent a message with the same client message ID, that message is
+// returned instead and created is false.
+func createDirectMessage(userID string, req SendMessageRequest) (message *models.Message, created bool, err error) {
+	// Return the original message for a retried send
+	if existing, err := findMessageByClientID(userID, req.ClientMessageID); err != nil || existing != nil {
+		if err == nil && !isSameDirectTarget(existing, req.ReceiverID) {
+			err = errClientMessageIDReused
+		}
+		return existing, false, err
+	}
+
 	// Validate input
 	if req.ReceiverID == "" || req.Content == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"error":   "Receiver ID and content are required",
-		})
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Receiver ID and content are required"}
+	}
+
+	if len(req.ClientMessageID) > MaxClientMessageIDLength {
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Client message ID is too long"}
+	}
+
+	if req.ReplyToID != "" && !isUUID(req.ReplyToID) {
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Invalid reply message ID"}
 	}
 
 	// Set default type
@@ -55,44 +137,70 @@ func SendMessage(c *fiber.Ctx) error {
 
 	// Validate message type
 	if req.Type != "text" && req.Type != "image" && req.Type != "file" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"error":   "Invalid message type. Must be text, image, or file",
-		})
+		return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Invalid message type. Must be text, image, or file"}
 	}
 
 	// Check if receiver exists
 	var receiverExists bool
-	err := database.Pool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", req.ReceiverID).Scan(&receiverExists)
+	err = database.Pool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", req.ReceiverID).Scan(&receiverExists)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Database error",
-		})
+		return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Database error"}
 	}
 
 	if !receiverExists {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"error":   "Receiver not found",
-		})
+		return nil, false, &requestError{fiber.StatusNotFound, errCodeNotFound, "Receiver not found"}
 	}
 
-	// Insert message
-	var message models.Message
+	// Refuse messages between users where either blocked the other
+	blocked, err := authorizer().IsBlocked(context.Background(), userID, req.ReceiverID)
+	if err != nil {
+		return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Database error"}
+	}
+
+	if blocked {
+		return nil, false, &requestError{fiber.StatusForbidden, errCodeForbidden, "You cannot send messages to this user"}
+	}
+
+	// Check if the replied message belongs to this chat
+	var replyToID *string
+	if req.ReplyToID != "" {
+		valid, err := isValidReplyTarget(req.ReplyToID, userID, req.ReceiverID, "")
+		if err != nil {
+			return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Database error"}
+		}
+
+		if !valid {
+			return nil, false, &requestError{fiber.StatusBadRequest, errCodeInvalidRequest, "Replied message not found in this chat"}
+		}
+		replyToID = &req.ReplyToID
+	}
+
+	var clientMessageID *string
+	if req.ClientMessageID != "" {
+		clientMessageID = &req.ClientMessageID
+	}
+
+	// Insert message (a concurrent retry with the same client message ID inserts nothing)
+	message = &models.Message{}
 	err = database.Pool.QueryRow(context.Background(), `
-		INSERT INTO messages (sender_id, receiver_id, content, type, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, sender_id, receiver_id, content, type, status, created_at, updated_at
-	`, userID, req.ReceiverID, req.Content, req.Type, "sent", time.Now(), time.Now()).
+		INSERT INTO messages (sender_id, receiver_id, content, type, status, reply_to_id, client_message_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (sender_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+		RETURNING id, sender_id, receiver_id, content, type, status, reply_to_id, client_message_id, created_at, updated_at
+	`, userID, req.ReceiverID, req.Content, req.Type, "sent", replyToID, clientMessageID, time.Now(), time.Now()).
 		Scan(&message.ID, &message.SenderID, &message.ReceiverID, &message.Content,
-			&message.Type, &message.Status, &message.CreatedAt, &message.UpdatedAt)
+			&message.Type, &message.Status, &message.ReplyToID, &message.ClientMessageID, &message.CreatedAt, &message.UpdatedAt)
+
+	if err == pgx.ErrNoRows {
+		existing, err := findMessageByClientID(userID, req.ClientMessageID)
+		if err == nil && (existing == nil || !isSameDirectTarget(existing, req.ReceiverID)) {
+			err = errClientMessageIDReused
+		}
+		return existing, false, err
+	}
 
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to send message",
-		})
+		return nil, false, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Failed to send message"}
 	}
 
 	// Broadcast message via WebSocket to receiver if online
@@ -102,22 +210,38 @@ func SendMessage(c *fiber.Ctx) error {
 			chatID = req.ReceiverID
 		}
 
+		payload := ws.MessagePayload{
+			ID:         message.ID,
+			ChatID:     chatID,
+			SenderID:   message.SenderID,
+			ReceiverID: *message.ReceiverID,
+			Content:    message.Content,
+			Type:       message.Type,
+			Status:     message.Status,
+			CreatedAt:  message.CreatedAt,
+		}
+		if message.ClientMessageID != nil {
+			payload.ClientMessageID = *message.ClientMessageID
+		}
+		if message.ReplyToID != nil {
+			payload.ReplyToID = *message.ReplyToID
+			payload.ReplyTo, _ = getReplyPreview(*message.ReplyToID)
+		}
+
 		wsMessage := ws.WSMessage{
-			Type: ws.EventMessageReceived,
-			Payload: ws.MessagePayload{
-				ID:         message.ID,
-				ChatID:     chatID,
-				SenderID:   message.SenderID,
-				ReceiverID: *message.ReceiverID,
-				Content:    message.Content,
-				Type:       message.Type,
-				Status:     message.Status,
-				CreatedAt:  message.CreatedAt,
-			},
+			Type:      ws.EventMessageReceived,
+			Payload:   payload,
 			Timestamp: time.Now(),
 		}
 		WSHub.BroadcastToUser(req.ReceiverID, wsMessage)
 
+		// Mirror the message to all of the sender's devices
+		WSHub.BroadcastToUser(userID, ws.WSMessage{
+			Type:      ws.EventMessageSent,
+			Payload:   payload,
+			Timestamp: time.Now(),
+		})
+
 		// Update status to delivered if receiver is online
 		if WSHub.IsUserOnline(req.ReceiverID) {
 			_, err := database.Pool.Exec(context.Background(),
@@ -143,10 +267,45 @@ func SendMessage(c *fiber.Ctx) error {
 		}
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"success": true,
-		"data":    message,
-	})
+	return message, true, nil
+}
+
+// errClientMessageIDReused is returned when a client message ID was already used for another chat
+var errClientMessageIDReused = &requestError{fiber.StatusConflict, errCodeConflict, "Client message ID was already used for a different message"}
+
+// findMessageByClientID returns the message a sender created with a client message ID, or nil if there is none
+func findMessageByClientID(senderID, clientMessageID string) (*models.Message, error) {
+	if clientMessageID == "" {
+		return nil, nil
+	}
+
+	message, err := scanMessage(database.Pool.QueryRow(context.Background(), `
+		SELECT `+messageColumns+`
+		FROM messages m
+		WHERE m.sender_id = $1 AND m.client_message_id = $2
+	`, senderID, clientMessageID))
+
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, &requestError{fiber.StatusInternalServerError, errCodeInternal, "Database error"}
+	}
+
+	message.ClientMessageID = &clientMessageID
+	applyReadPrivacy(&message, senderID)
+	return &message, nil
+}
+
+// isSameDirectTarget checks that an existing message was sent to the given receiver
+func isSameDirectTarget(message *models.Message, receiverID string) bool {
+	return message.ReceiverID != nil && *message.ReceiverID == receiverID
+}
+
+// isSameGroupTarget checks that an existing message was sent to the given group
+func isSameGroupTarget(message *models.Message, groupID string) bool {
+	return message.GroupID != nil && *message.GroupID == groupID
 }
 
 // GetMessages returns message history between two users
@@ -154,7 +313,20 @@ func GetMessages(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 	chatID := c.Params("chatId") // chatId is the other user's ID
 
-	// Pagination
+	// Keyset pagination (before/after/around cursors)
+	pageReq, err := parseMessagePageRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid pagination parameters",
+		})
+	}
+
+	if pageReq != nil {
+		return respondMessagePage(c, userID, chatID, directConversationCondition, pageReq)
+	}
+
+	// Legacy page/limit pagination
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
 	offset := (page - 1) * limit
@@ -168,9 +340,10 @@ func GetMessages(c *fiber.Ctx) error {
 
 	// Get total count
 	var total int
-	err := database.Pool.QueryRow(context.Background(), `
-		SELECT COUNT(*) FROM messages
-		WHERE (sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1)
+	err = database.Pool.QueryRow(context.Background(), `
+		SELECT COUNT(*) FROM messages m
+		WHERE `+directConversationCondition+`
+		AND `+notHiddenCondition+`
 	`, userID, chatID).Scan(&total)
 
 	if err != nil {
@@ -182,12 +355,10 @@ func GetMessages(c *fiber.Ctx) error {
 
 	// Get messages with sender info
 	rows, err := database.Pool.Query(context.Background(), `
-		SELECT 
-			m.id, m.sender_id, m.receiver_id, m.content, m.type, m.status, m.created_at, m.updated_at,
-			u.id, u.unique_id, u.email, u.name, u.avatar, u.auth_provider, u.is_online, u.last_seen, u.created_at, u.updated_at
-		FROM messages m
-		INNER JOIN users u ON m.sender_id = u.id
-		WHERE (m.sender_id = $1 AND m.receiver_id = $2) OR (m.sender_id = $2 AND m.receiver_id = $1)
+		SELECT `+messageWithSenderColumns+`
+		FROM `+messageWithSenderJoins+`
+		WHERE `+directConversationCondition+`
+		AND `+notHiddenCondition+`
 		ORDER BY m.created_at ASC
 		LIMIT $3 OFFSET $4
 	`, userID, chatID, limit, offset)
@@ -203,37 +374,27 @@ func GetMessages(c *fiber.Ctx) error {
 	var messages []models.MessageWithSender
 
 	for rows.Next() {
-		var message models.Message
-		var sender models.User
-
-		err := rows.Scan(
-			&message.ID, &message.SenderID, &message.ReceiverID, &message.Content,
-			&message.Type, &message.Status, &message.CreatedAt, &message.UpdatedAt,
-			&sender.ID, &sender.UniqueID, &sender.Email, &sender.Name, &sender.Avatar,
-			&sender.AuthProvider, &sender.IsOnline, &sender.LastSeen, &sender.CreatedAt, &sender.UpdatedAt,
-		)
-
+		message, err := scanMessageWithSender(rows)
 		if err != nil {
 			continue
 		}
 
-		messages = append(messages, models.MessageWithSender{
-			ID:         message.ID,
-			Sender:     sender.ToResponse(),
-			ReceiverID: message.ReceiverID,
-			GroupID:    message.GroupID,
-			Content:    message.Content,
-			Type:       message.Type,
-			Status:     message.Status,
-			CreatedAt:  message.CreatedAt,
-			UpdatedAt:  message.UpdatedAt,
-		})
+		messages = append(messages, message)
 	}
 
 	if messages == nil {
 		messages = []models.MessageWithSender{}
 	}
 
+	if err := attachReactions(messages, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	applyMessagePrivacy(messages, userID)
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data": fiber.Map{
@@ -302,30 +463,30 @@ func MarkAsRead(c *fiber.Ctx) error {
 		var senderID string
 		if len(req.MessageIDs) > 0 {
 			// Get sender from first message
-			err := database.Pool.QueryRow(context.Background(),
+			database.Pool.QueryRow(context.Background(),
 				"SELECT sender_id FROM messages WHERE id = $1", req.MessageIDs[0]).Scan(&senderID)
-			if err == nil {
-				for _, msgID := range req.MessageIDs {
-					readMessage := ws.WSMessage{
-						Type: ws.EventMessageRead,
-						Payload: ws.MessageStatusPayload{
-							MessageID: msgID,
-							Status:    "read",
-							UpdatedAt: time.Now(),
-						},
-						Timestamp: time.Now(),
-					}
-					WSHub.BroadcastToUser(senderID, readMessage)
-				}
-			}
 		} else if req.SenderID != "" {
 			senderID = req.SenderID
 		} else if req.ChatID != "" {
 			senderID = req.ChatID
 		}
 
-		// Notify sender that all their messages were read
-		if senderID != "" {
+		// Readers can hide read receipts from the sender
+		if senderID != "" && sharesReadReceipts(userID, senderID) {
+			for _, msgID := range req.MessageIDs {
+				readMessage := ws.WSMessage{
+					Type: ws.EventMessageRead,
+					Payload: ws.MessageStatusPayload{
+						MessageID: msgID,
+						Status:    "read",
+						UpdatedAt: time.Now(),
+					},
+					Timestamp: time.Now(),
+				}
+				WSHub.BroadcastToUser(senderID, readMessage)
+			}
+
+			// Notify sender that all their messages were read
 			readMessage := ws.WSMessage{
 				Type: ws.EventMessageRead,
 				Payload: fiber.Map{
@@ -373,6 +534,13 @@ func UpdateMessageStatus(c *fiber.Ctx) error {
 		})
 	}
 
+	if !isUUID(messageID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found or you don't have permission",
+		})
+	}
+
 	// Update message status (only if user is the receiver)
 	var message models.Message
 	err := database.Pool.QueryRow(context.Background(), `
@@ -405,3 +573,699 @@ func UpdateMessageStatus(c *fiber.Ctx) error {
 		},
 	})
 }
+
+// EditMessageRequest represents edit message request body
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// defaultMessageEditWindow is used when MESSAGE_EDIT_WINDOW is not set
+const defaultMessageEditWindow = 15 * time.Minute
+
+// messageColumns selects a message row (aliased as m)
+const messageColumns = `m.id, m.sender_id, m.receiver_id, m.group_id, m.content, m.type, m.status, m.reply_to_id, m.edited_at, m.deleted_at, m.created_at, m.updated_at`
+
+// messageWithSenderColumns selects a message row (aliased as m) with its sender (aliased as u)
+// and a preview of the message it replies to. Use together with messageWithSenderJoins.
+const messageWithSenderColumns = messageColumns + `,
+	u.id, u.unique_id, u.name, u.avatar,
+	r.id, r.sender_id, ru.name, r.type, LEFT(r.content, ` + replyPreviewLength + `), r.deleted_at IS NOT NULL`
+
+// messageWithSenderJoins is the FROM clause matching messageWithSenderColumns
+const messageWithSenderJoins = `messages m
+		INNER JOIN users u ON m.sender_id = u.id
+		LEFT JOIN messages r ON m.reply_to_id = r.id
+		LEFT JOIN users ru ON r.sender_id = ru.id`
+
+// replyPreviewLength is the maximum number of characters shown in a reply preview
+const replyPreviewLength = "100"
+
+// notHiddenCondition excludes messages the user ($1) deleted for themselves
+const notHiddenCondition = `NOT EXISTS(SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = $1)`
+
+// scanMessage scans a row selected with messageColumns
+func scanMessage(row pgx.Row) (models.Message, error) {
+	var message models.Message
+	err := row.Scan(
+		&message.ID, &message.SenderID, &message.ReceiverID, &message.GroupID, &message.Content,
+		&message.Type, &message.Status, &message.ReplyToID, &message.EditedAt, &message.DeletedAt,
+		&message.CreatedAt, &message.UpdatedAt,
+	)
+	return message, err
+}
+
+// scanMessageWithSender scans a row selected with messageWithSenderColumns
+func scanMessageWithSender(row pgx.Row) (models.MessageWithSender, error) {
+	var message models.Message
+	var sender models.MessageSender
+	var replyID, replySenderID, replySenderName, replyType, replyContent *string
+	var replyDeleted bool
+
+	err := row.Scan(
+		&message.ID, &message.SenderID, &message.ReceiverID, &message.GroupID, &message.Content,
+		&message.Type, &message.Status, &message.ReplyToID, &message.EditedAt, &message.DeletedAt,
+		&message.CreatedAt, &message.UpdatedAt,
+		&sender.ID, &sender.UniqueID, &sender.Name, &sender.Avatar,
+		&replyID, &replySenderID, &replySenderName, &replyType, &replyContent, &replyDeleted,
+	)
+
+	if err != nil {
+		return models.MessageWithSender{}, err
+	}
+
+	var replyTo *models.ReplyPreview
+	if replyID != nil {
+		replyTo = &models.ReplyPreview{
+			ID:         *replyID,
+			SenderID:   *replySenderID,
+			SenderName: *replySenderName,
+			Type:       *replyType,
+			Content:    *replyContent,
+			IsDeleted:  replyDeleted,
+		}
+	}
+
+	return models.MessageWithSender{
+		ID:         message.ID,
+		Sender:     sender,
+		ReceiverID: message.ReceiverID,
+		GroupID:    message.GroupID,
+		Content:    message.Content,
+		Type:       message.Type,
+		Status:     message.Status,
+		ReplyToID:  message.ReplyToID,
+		ReplyTo:    replyTo,
+		EditedAt:   message.EditedAt,
+		IsEdited:   message.EditedAt != nil,
+		DeletedAt:  message.DeletedAt,
+		IsDeleted:  message.DeletedAt != nil,
+		CreatedAt:  message.CreatedAt,
+		UpdatedAt:  message.UpdatedAt,
+	}, nil
+}
+
+// getReplyPreview returns the preview of a message used in replies
+func getReplyPreview(messageID string) (*models.ReplyPreview, error) {
+	var preview models.ReplyPreview
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT r.id, r.sender_id, ru.name, r.type, LEFT(r.content, `+replyPreviewLength+`), r.deleted_at IS NOT NULL
+		FROM messages r
+		INNER JOIN users ru ON r.sender_id = ru.id
+		WHERE r.id = $1
+	`, messageID).Scan(&preview.ID, &preview.SenderID, &preview.SenderName, &preview.Type, &preview.Content, &preview.IsDeleted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &preview, nil
+}
+
+// isUUID reports whether s is a valid UUID, so malformed IDs can be refused before querying
+func isUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil
+}
+
+// isValidReplyTarget checks that a message exists, is not deleted and belongs to the given DM pair or group
+func isValidReplyTarget(replyToID, senderID, receiverID, groupID string) (bool, error) {
+	var valid bool
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT EXISTS(
+			SELECT 1 FROM messages
+			WHERE id = $1 AND deleted_at IS NULL AND (
+				($4 <> '' AND group_id::text = $4)
+				OR ($4 = '' AND ((sender_id = $2 AND receiver_id::text = $3) OR (sender_id::text = $3 AND receiver_id = $2)))
+			)
+		)
+	`, replyToID, senderID, receiverID, groupID).Scan(&valid)
+
+	return valid, err
+}
+
+// getAccessibleMessage returns a message if the user is a participant of its DM or a member of its group.
+// It returns pgx.ErrNoRows when the message does not exist or the user has no access to it.
+func getAccessibleMessage(messageID, userID string) (models.Message, error) {
+	// A malformed ID names no message
+	if !isUUID(messageID) {
+		return models.Message{}, pgx.ErrNoRows
+	}
+
+	return scanMessage(database.Pool.QueryRow(context.Background(), `
+		SELECT `+messageColumns+`
+		FROM messages m
+		WHERE m.id = $1 AND (
+			(m.group_id IS NULL AND (m.sender_id = $2 OR m.receiver_id = $2))
+			OR EXISTS(SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = $2)
+		)
+	`, messageID, userID))
+}
+
+// broadcastMessageEvent sends an event about a message to everyone in its conversation except excludeUserID
+func broadcastMessageEvent(message models.Message, wsMessage ws.WSMessage, excludeUserID string) {
+	if WSHub == nil {
+		return
+	}
+
+	if message.GroupID != nil {
+		WSHub.BroadcastToGroup(*message.GroupID, wsMessage, excludeUserID)
+		return
+	}
+
+	var recipients []string
+	if message.SenderID != excludeUserID {
+		recipients = append(recipients, message.SenderID)
+	}
+	if message.ReceiverID != nil && *message.ReceiverID != excludeUserID {
+		recipients = append(recipients, *message.ReceiverID)
+	}
+	WSHub.BroadcastToUsers(recipients, wsMessage)
+}
+
+// getMessageEditWindow returns how long after sending a message can still be edited (0 means no limit)
+func getMessageEditWindow() time.Duration {
+	value := os.Getenv("MESSAGE_EDIT_WINDOW")
+	if value == "" {
+		return defaultMessageEditWindow
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		log.Printf("Invalid MESSAGE_EDIT_WINDOW %q, using default", value)
+		return defaultMessageEditWindow
+	}
+
+	return window
+}
+
+// EditMessage edits the content of a message sent by the current user
+func EditMessage(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+
+	var req EditMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	// Validate input
+	if req.Content == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Content is required",
+		})
+	}
+
+	if !isUUID(messageID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	// Start transaction
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	// Lock the message so concurrent edits are recorded in order
+	message, err := scanMessage(tx.QueryRow(context.Background(), `
+		SELECT `+messageColumns+`
+		FROM messages m
+		WHERE m.id = $1
+		FOR UPDATE
+	`, messageID))
+
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if message.SenderID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "You can only edit your own messages",
+		})
+	}
+
+	if message.DeletedAt != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Deleted messages cannot be edited",
+		})
+	}
+
+	if message.Type != "text" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Only text messages can be edited",
+		})
+	}
+
+	if window := getMessageEditWindow(); window > 0 && time.Since(message.CreatedAt) > window {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "Edit window for this message has expired",
+		})
+	}
+
+	// Group messages can only be edited by current members
+	if message.GroupID != nil {
+		var isMember bool
+		err = tx.QueryRow(context.Background(), `
+			SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = $1 AND user_id = $2)
+		`, *message.GroupID, userID).Scan(&isMember)
+
+		if err != nil || !isMember {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"error":   "You are not a member of this group",
+			})
+		}
+	}
+
+	applyReadPrivacy(&message, userID)
+
+	// Nothing to do if content is unchanged
+	if message.Content == req.Content {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"data":    message,
+		})
+	}
+
+	// Keep the previous revision
+	now := time.Now()
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO message_edits (message_id, edited_by, previous_content, edited_at)
+		VALUES ($1, $2, $3, $4)
+	`, message.ID, userID, message.Content, now)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to save message history",
+		})
+	}
+
+	// Update message content
+	message, err = scanMessage(tx.QueryRow(context.Background(), `
+		UPDATE messages m
+		SET content = $1, edited_at = $2, updated_at = $2
+		WHERE m.id = $3
+		RETURNING `+messageColumns,
+		req.Content, now, message.ID))
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to edit message",
+		})
+	}
+
+	// Commit transaction
+	if err := tx.Commit(context.Background()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to commit transaction",
+		})
+	}
+
+	// Broadcast edit via WebSocket to the DM peer or group members
+	payload := ws.MessageEditedPayload{
+		MessageID: message.ID,
+		SenderID:  message.SenderID,
+		Content:   message.Content,
+		EditedAt:  *message.EditedAt,
+	}
+	if message.GroupID != nil {
+		payload.GroupID = *message.GroupID
+	} else {
+		payload.ChatID = userID // For the receiver, the chatId is the sender's ID
+	}
+
+	broadcastMessageEvent(message, ws.WSMessage{
+		Type:      ws.EventMessageEdited,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, userID)
+
+	applyReadPrivacy(&message, userID)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// GetMessageEdits returns the previous revisions of a message, newest first
+func GetMessageEdits(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+
+	// Check if user can see the message
+	message, err := getAccessibleMessage(messageID, userID)
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT id, message_id, edited_by, previous_content, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at DESC
+	`, message.ID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	var edits []models.MessageEdit
+
+	for rows.Next() {
+		var edit models.MessageEdit
+		err := rows.Scan(&edit.ID, &edit.MessageID, &edit.EditedBy, &edit.PreviousContent, &edit.EditedAt)
+		if err != nil {
+			continue
+		}
+
+		edits = append(edits, edit)
+	}
+
+	if edits == nil {
+		edits = []models.MessageEdit{}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"message": message,
+			"edits":   edits,
+		},
+	})
+}
+
+// DeleteMessage deletes a message for the current user only (scope=me) or for everyone (scope=everyone)
+func DeleteMessage(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+	scope := c.Query("scope", "me")
+
+	// Validate scope
+	if scope != "me" && scope != "everyone" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid scope. Must be me or everyone",
+		})
+	}
+
+	// Check if user can see the message
+	message, err := getAccessibleMessage(messageID, userID)
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	deletedAt := time.Now()
+
+	if scope == "me" {
+		// Hide the message for the current user only
+		_, err = database.Pool.Exec(context.Background(), `
+			INSERT INTO hidden_messages (message_id, user_id, hidden_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (message_id, user_id) DO NOTHING
+		`, message.ID, userID, deletedAt)
+
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to delete message",
+			})
+		}
+
+		// Notify the user's own client so the bubble is removed
+		if WSHub != nil {
+			payload := ws.MessageDeletedPayload{
+				MessageID: message.ID,
+				Scope:     scope,
+				DeletedAt: deletedAt,
+			}
+			if message.GroupID != nil {
+				payload.GroupID = *message.GroupID
+			} else {
+				payload.ChatID = message.SenderID
+				if message.SenderID == userID {
+					payload.ChatID = *message.ReceiverID
+				}
+			}
+
+			WSHub.BroadcastToUser(userID, ws.WSMessage{
+				Type:      ws.EventMessageDeleted,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Message deleted for you",
+		})
+	}
+
+	// Only the sender can delete a message for everyone
+	if message.SenderID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "You can only delete your own messages for everyone",
+		})
+	}
+
+	if message.DeletedAt != nil {
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Message deleted for everyone",
+		})
+	}
+
+	// Start transaction
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	// Tombstone the message, dropping its content
+	_, err = tx.Exec(context.Background(), `
+		UPDATE messages SET content = '', deleted_at = $1 WHERE id = $2
+	`, deletedAt, message.ID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete message",
+		})
+	}
+
+	// Previous revisions would still expose the content
+	_, err = tx.Exec(context.Background(), "DELETE FROM message_edits WHERE message_id = $1", message.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete message history",
+		})
+	}
+
+	// Reactions are not kept on deleted messages
+	_, err = tx.Exec(context.Background(), "DELETE FROM message_reactions WHERE message_id = $1", message.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to delete message reactions",
+		})
+	}
+
+	// Commit transaction
+	if err := tx.Commit(context.Background()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to commit transaction",
+		})
+	}
+
+	// Broadcast deletion via WebSocket to everyone in the conversation, including the sender
+	if WSHub != nil {
+		payload := ws.MessageDeletedPayload{
+			MessageID: message.ID,
+			Scope:     scope,
+			DeletedAt: deletedAt,
+		}
+
+		if message.GroupID != nil {
+			payload.GroupID = *message.GroupID
+			WSHub.BroadcastToGroup(*message.GroupID, ws.WSMessage{
+				Type:      ws.EventMessageDeleted,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			}, "")
+		} else {
+			// Each participant sees the chat under the other user's ID
+			receiverPayload := payload
+			receiverPayload.ChatID = userID
+			WSHub.BroadcastToUser(*message.ReceiverID, ws.WSMessage{
+				Type:      ws.EventMessageDeleted,
+				Payload:   receiverPayload,
+				Timestamp: time.Now(),
+			})
+
+			payload.ChatID = *message.ReceiverID
+			WSHub.BroadcastToUser(userID, ws.WSMessage{
+				Type:      ws.EventMessageDeleted,
+				Payload:   payload,
+				Timestamp: time.Now(),
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Message deleted for everyone",
+	})
+}
+
+// GetThread returns a root message with all replies to it (including nested replies), oldest first
+func GetThread(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+
+	// Check if user can see the root message
+	if _, err := getAccessibleMessage(messageID, userID); err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	// Get root message with sender info, unless the user deleted it for themselves
+	root, err := scanMessageWithSender(database.Pool.QueryRow(context.Background(), `
+		SELECT `+messageWithSenderColumns+`
+		FROM `+messageWithSenderJoins+`
+		WHERE m.id = $2 AND `+notHiddenCondition+`
+	`, userID, messageID))
+
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	// Get all replies in the thread
+	rows, err := database.Pool.Query(context.Background(), `
+		WITH RECURSIVE thread AS (
+			SELECT id FROM messages WHERE reply_to_id = $2
+			UNION
+			SELECT msg.id FROM messages msg INNER JOIN thread t ON msg.reply_to_id = t.id
+		)
+		SELECT `+messageWithSenderColumns+`
+		FROM `+messageWithSenderJoins+`
+		WHERE m.id IN (SELECT id FROM thread) AND `+notHiddenCondition+`
+		ORDER BY m.created_at ASC
+	`, userID, messageID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	var replies []models.MessageWithSender
+
+	for rows.Next() {
+		message, err := scanMessageWithSender(rows)
+		if err != nil {
+			continue
+		}
+
+		replies = append(replies, message)
+	}
+
+	if replies == nil {
+		replies = []models.MessageWithSender{}
+	}
+
+	thread := append([]models.MessageWithSender{root}, replies...)
+	if err := attachReactions(thread, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	applyMessagePrivacy(thread, userID)
+	root, replies = thread[0], thread[1:]
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"root":    root,
+			"replies": replies,
+		},
+	})
+}
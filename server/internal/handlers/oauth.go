@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -112,15 +113,26 @@ func GoogleOAuthCallback(c *fiber.Ctx) error {
 		})
 	}
 
+	// Accounts are matched by email, so only trust addresses Google has verified
+	if !googleUser.EmailVerified {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success": false,
+			"error":   "Your Google account's email is not verified",
+		})
+	}
+
 	// Check if user exists
 	var user models.User
+	var twoFactorEnabled, emailVerified bool
 	selectQuery := `
-		SELECT id, unique_id, email, name, avatar, auth_provider, google_id, is_online, last_seen, created_at, updated_at
+		SELECT id, unique_id, email, name, avatar, auth_provider, google_id, is_online, last_seen, created_at, updated_at,
+			totp_enabled_at IS NOT NULL, email_verified_at IS NOT NULL
 		FROM users WHERE email = $1
 	`
 	err = database.Pool.QueryRow(context.Background(), selectQuery, googleUser.Email).
 		Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name, &user.Avatar,
-			&user.AuthProvider, &user.GoogleID, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt)
+			&user.AuthProvider, &user.GoogleID, &user.IsOnline, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+			&twoFactorEnabled, &emailVerified)
 
 	if err == pgx.ErrNoRows {
 		// Create new user
@@ -169,55 +181,63 @@ func GoogleOAuthCallback(c *fiber.Ctx) error {
 			"success": false,
 			"error":   fmt.Sprintf("Database error: %v", err),
 		})
+	} else if !emailVerified {
+		// Anyone can register an address they don't own, so the password of an
+		// unverified account may not be the owner's. Drop it and its sessions
+		// before Google's verification makes the account usable, leaving a
+		// Google account like one created here.
+		if _, err := revokeSessions(user.ID, nil, ""); err != nil {
+			log.Printf("Failed to revoke sessions of unverified account %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to sign in",
+			})
+		}
+
+		_, err = database.Pool.Exec(context.Background(), `
+			UPDATE users SET password_hash = NULL, auth_provider = 'google', google_id = $1, updated_at = $2
+			WHERE id = $3
+		`, googleUser.Sub, time.Now(), user.ID)
+		if err != nil {
+			log.Printf("Failed to clear credentials of unverified account %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to sign in",
+			})
+		}
+		user.AuthProvider = "google"
+		user.GoogleID = &googleUser.Sub
 	}
 
-	// Update online status
-	_, _ = database.Pool.Exec(context.Background(), "UPDATE users SET is_online = true, last_seen = $1 WHERE id = $2", time.Now(), user.ID)
-	user.IsOnline = true
+	// Google stands in for the password only. With two-factor authentication the
+	// login page asks for a code and the session starts in POST /auth/2fa/verify.
+	// The token goes in the fragment, which stays out of server logs and Referer headers.
+	if twoFactorEnabled {
+		mfaToken, err := utils.GenerateMFAToken(user.ID, user.Email, user.UniqueID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to generate token",
+			})
+		}
 
-	// Generate JWT tokens
-	token, err := utils.GenerateToken(user.ID, user.Email, user.UniqueID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to generate token",
-		})
+		return c.Redirect(frontendURL() + "/login#mfaToken=" + url.QueryEscape(mfaToken))
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email, user.UniqueID)
-	if err != nil {
+	// Update online status; Google has verified the email
+	_, _ = database.Pool.Exec(context.Background(), "UPDATE users SET is_online = true, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END, email_verified_at = COALESCE(email_verified_at, $1) WHERE id = $2", time.Now(), user.ID)
+	user.IsOnline = true
+
+	// Start a session for this device
+	if err := startSession(c, &user); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-			"error":   "Failed to generate refresh token",
+			"error":   "Failed to create session",
 		})
 	}
 
-	// Set cookies
-	c.Cookie(&fiber.Cookie{
-		Name:     "token",
-		Value:    token,
-		HTTPOnly: true,
-		Secure:   false,
-		SameSite: "Lax",
-		MaxAge:   900, // 15 minutes
-	})
-
-	c.Cookie(&fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HTTPOnly: true,
-		Secure:   false,
-		SameSite: "Lax",
-		MaxAge:   604800, // 7 days
-	})
-
 	// Redirect to frontend
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
-	}
-
-	return c.Redirect(frontendURL + "/chat")
+	return c.Redirect(frontendURL() + "/chat")
 }
 
 // TokenResponse represents Google OAuth token response
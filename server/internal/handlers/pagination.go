@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Conditions selecting the messages of a conversation, with $1 = current user ID and $2 = conversation ID
+const (
+	directConversationCondition = `((m.sender_id = $1 AND m.receiver_id = $2) OR (m.sender_id = $2 AND m.receiver_id = $1))`
+	groupConversationCondition  = `m.group_id = $2`
+)
+
+// errInvalidCursor is returned when a pagination cursor cannot be decoded
+var errInvalidCursor = errors.New("invalid cursor")
+
+// messageCursor is a position in a conversation, ordered by (created_at, id)
+type messageCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// messagePageRequest describes a keyset pagination request
+type messagePageRequest struct {
+	Before *messageCursor // Messages older than this cursor
+	After  *messageCursor // Messages newer than this cursor
+	Around string         // Message ID to center the page on
+	Limit  int
+}
+
+// encodeMessageCursor encodes a message position as an opaque cursor
+func encodeMessageCursor(createdAt time.Time, id string) string {
+	raw := strconv.FormatInt(createdAt.UnixMicro(), 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeMessageCursor decodes a cursor created by encodeMessageCursor
+func decodeMessageCursor(cursor string) (*messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	micros, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, errInvalidCursor
+	}
+
+	unixMicro, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &messageCursor{CreatedAt: time.UnixMicro(unixMicro), ID: id}, nil
+}
+
+// parseMessagePageRequest reads keyset pagination query parameters. Keyset mode is used
+// only when before, after or around is given; an empty before starts from the latest
+// messages. It returns nil when the request uses the page/limit mode.
+func parseMessagePageRequest(c *fiber.Ctx) (*messagePageRequest, error) {
+	args := c.Request().URI().QueryArgs()
+	if !args.Has("before") && !args.Has("after") && !args.Has("around") {
+		return nil, nil
+	}
+
+	before := c.Query("before")
+	after := c.Query("after")
+	around := c.Query("around")
+
+	if (args.Has("after") && after == "") || (args.Has("around") && around == "") {
+		return nil, errors.New("after and around need a value")
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	req := &messagePageRequest{Limit: limit}
+
+	set := 0
+	for _, key := range []string{"before", "after", "around"} {
+		if args.Has(key) {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.New("only one of before, after or around can be used")
+	}
+
+	var err error
+	switch {
+	case before != "":
+		req.Before, err = decodeMessageCursor(before)
+	case after != "":
+		req.After, err = decodeMessageCursor(after)
+	case around != "":
+		if _, parseErr := uuid.Parse(around); parseErr != nil {
+			err = errors.New("invalid message ID")
+		}
+		req.Around = around
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// queryMessages runs a message query and scans the results
+func queryMessages(query string, args ...interface{}) ([]models.MessageWithSender, error) {
+	rows, err := database.Pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.MessageWithSender
+
+	for rows.Next() {
+		message, err := scanMessageWithSender(rows)
+		if err != nil {
+			continue
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// fetchOlderMessages returns up to limit messages older than the cursor (or the latest messages
+// when cursor is nil) in ascending order, and whether even older messages exist
+func fetchOlderMessages(userID, conversationID, condition string, cursor *messageCursor, limit int, inclusive bool) ([]models.MessageWithSender, bool, error) {
+	query := `
+		SELECT ` + messageWithSenderColumns + `
+		FROM ` + messageWithSenderJoins + `
+		WHERE ` + condition + ` AND ` + notHiddenCondition
+	args := []interface{}{userID, conversationID}
+
+	if cursor != nil {
+		operator := "<"
+		if inclusive {
+			operator = "<="
+		}
+		query += ` AND (m.created_at, m.id) ` + operator + ` ($3, $4)`
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	}
+
+	query += ` ORDER BY m.created_at DESC, m.id DESC LIMIT ` + strconv.Itoa(limit+1)
+
+	messages, err := queryMessages(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// Return in chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, hasMore, nil
+}
+
+// fetchNewerMessages returns up to limit messages newer than the cursor in ascending order,
+// and whether even newer messages exist
+func fetchNewerMessages(userID, conversationID, condition string, cursor *messageCursor, limit int, inclusive bool) ([]models.MessageWithSender, bool, error) {
+	operator := ">"
+	if inclusive {
+		operator = ">="
+	}
+
+	messages, err := queryMessages(`
+		SELECT `+messageWithSenderColumns+`
+		FROM `+messageWithSenderJoins+`
+		WHERE `+condition+` AND `+notHiddenCondition+`
+		AND (m.created_at, m.id) `+operator+` ($3, $4)
+		ORDER BY m.created_at ASC, m.id ASC
+		LIMIT `+strconv.Itoa(limit+1),
+		userID, conversationID, cursor.CreatedAt, cursor.ID)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	return messages, hasMore, nil
+}
+
+// respondMessagePage writes a keyset-paginated page of messages from a conversation
+func respondMessagePage(c *fiber.Ctx, userID, conversationID, condition string, req *messagePageRequest) error {
+	var messages []models.MessageWithSender
+	var hasOlder, hasNewer bool
+	var err error
+
+	switch {
+	case req.After != nil:
+		messages, hasNewer, err = fetchNewerMessages(userID, conversationID, condition, req.After, req.Limit, false)
+		hasOlder = len(messages) > 0
+
+	case req.Around != "":
+		// Find the target message in this conversation
+		var target messageCursor
+		err = database.Pool.QueryRow(context.Background(), `
+			SELECT m.created_at, m.id FROM messages m
+			WHERE `+condition+` AND m.id = $3
+		`, userID, conversationID, req.Around).Scan(&target.CreatedAt, &target.ID)
+
+		if err == pgx.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"error":   "Message not found in this chat",
+			})
+		}
+
+		if err != nil {
+			break
+		}
+
+		// Split the page around the target, which starts the newer half
+		olderLimit := req.Limit / 2
+		var older, newer []models.MessageWithSender
+		older, hasOlder, err = fetchOlderMessages(userID, conversationID, condition, &target, olderLimit, false)
+		if err != nil {
+			break
+		}
+
+		newer, hasNewer, err = fetchNewerMessages(userID, conversationID, condition, &target, req.Limit-olderLimit, true)
+		messages = append(older, newer...)
+
+	default:
+		messages, hasOlder, err = fetchOlderMessages(userID, conversationID, condition, req.Before, req.Limit, false)
+		hasNewer = req.Before != nil && len(messages) > 0
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if messages == nil {
+		messages = []models.MessageWithSender{}
+	}
+
+	if err := attachReactions(messages, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	applyMessagePrivacy(messages, userID)
+
+	// prevCursor loads older messages, nextCursor loads newer messages
+	var prevCursor, nextCursor *string
+	if len(messages) > 0 {
+		if hasOlder {
+			cursor := encodeMessageCursor(messages[0].CreatedAt, messages[0].ID)
+			prevCursor = &cursor
+		}
+		if hasNewer {
+			last := messages[len(messages)-1]
+			cursor := encodeMessageCursor(last.CreatedAt, last.ID)
+			nextCursor = &cursor
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"messages": messages,
+			"pagination": fiber.Map{
+				"limit":      req.Limit,
+				"prevCursor": prevCursor,
+				"nextCursor": nextCursor,
+			},
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"log"
+
+	ws "ngabarin/server/internal/websocket"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPresence returns the current user's status and custom status text
+func GetPresence(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	if WSHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "WebSocket hub not initialized",
+		})
+	}
+
+	presence, err := WSHub.GetPresence(userID)
+	if err != nil {
+		log.Printf("Failed to load presence for %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to load presence",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    presence,
+	})
+}
+
+// SetPresence sets the current user's status (online, idle, away, dnd or invisible)
+// with an optional custom status text and expiry
+func SetPresence(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	if WSHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "WebSocket hub not initialized",
+		})
+	}
+
+	var req ws.SetPresenceFrame
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	presence, errPayload := WSHub.SetPresence(userID, req)
+	if errPayload != nil {
+		return c.Status(eventErrorStatus(errPayload.Code)).JSON(fiber.Map{
+			"success": false,
+			"error":   errPayload.Message,
+			"code":    errPayload.Code,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    presence,
+	})
+}
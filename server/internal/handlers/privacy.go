@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UpdatePrivacyRequest changes some of the user's privacy settings; omitted fields are left as is
+type UpdatePrivacyRequest struct {
+	LastSeen     *models.Audience `json:"lastSeen"`
+	Online       *models.Audience `json:"online"`
+	Avatar       *models.Audience `json:"avatar"`
+	ReadReceipts *models.Audience `json:"readReceipts"`
+}
+
+// GetPrivacySettings returns who may see the current user's last seen, online status, avatar and read receipts
+func GetPrivacySettings(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var settings models.PrivacySettings
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT privacy_last_seen, privacy_online, privacy_avatar, privacy_read_receipts
+		FROM users WHERE id = $1
+	`, userID).Scan(&settings.LastSeen, &settings.Online, &settings.Avatar, &settings.ReadReceipts)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdatePrivacySettings sets each given setting to everyone, contacts or nobody
+func UpdatePrivacySettings(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req UpdatePrivacyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	for _, audience := range []*models.Audience{req.LastSeen, req.Online, req.Avatar, req.ReadReceipts} {
+		if audience != nil && !audience.IsValid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Privacy settings must be everyone, contacts or nobody",
+			})
+		}
+	}
+
+	var settings models.PrivacySettings
+	err := database.Pool.QueryRow(context.Background(), `
+		UPDATE users SET
+			privacy_last_seen = COALESCE($1, privacy_last_seen),
+			privacy_online = COALESCE($2, privacy_online),
+			privacy_avatar = COALESCE($3, privacy_avatar),
+			privacy_read_receipts = COALESCE($4, privacy_read_receipts),
+			updated_at = $5
+		WHERE id = $6
+		RETURNING privacy_last_seen, privacy_online, privacy_avatar, privacy_read_receipts
+	`, req.LastSeen, req.Online, req.Avatar, req.ReadReceipts, time.Now(), userID).
+		Scan(&settings.LastSeen, &settings.Online, &settings.Avatar, &settings.ReadReceipts)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to update privacy settings",
+		})
+	}
+
+	// Contacts are shown the user's presence as the new settings allow
+	if WSHub != nil && (req.LastSeen != nil || req.Online != nil) {
+		go WSHub.RefreshPresence(userID)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// privacyView is what a viewer is allowed to see of a user
+type privacyView struct {
+	settings  models.PrivacySettings
+	isContact bool // The viewer is in the user's contact list
+}
+
+// loadPrivacyViews returns the privacy settings of each user and whether the viewer
+// is their contact. Users whose settings cannot be loaded share nothing.
+func loadPrivacyViews(viewerID string, userIDs []string) map[string]privacyView {
+	views := make(map[string]privacyView, len(userIDs))
+	if len(userIDs) == 0 {
+		return views
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT u.id, u.privacy_last_seen, u.privacy_online, u.privacy_avatar, u.privacy_read_receipts,
+			EXISTS(SELECT 1 FROM contacts WHERE user_id = u.id AND contact_id = $2)
+		FROM users u
+		WHERE u.id = ANY($1::uuid[])
+	`, userIDs, viewerID)
+
+	if err == nil {
+		for rows.Next() {
+			var userID string
+			var view privacyView
+			if err := rows.Scan(&userID, &view.settings.LastSeen, &view.settings.Online,
+				&view.settings.Avatar, &view.settings.ReadReceipts, &view.isContact); err != nil {
+				continue
+			}
+			views[userID] = view
+		}
+		rows.Close()
+	} else {
+		log.Printf("Failed to load privacy settings: %v", err)
+	}
+
+	for _, userID := range userIDs {
+		if _, ok := views[userID]; !ok {
+			views[userID] = privacyView{settings: models.PrivacySettings{
+				LastSeen:     models.AudienceNobody,
+				Online:       models.AudienceNobody,
+				Avatar:       models.AudienceNobody,
+				ReadReceipts: models.AudienceNobody,
+			}}
+		}
+	}
+
+	return views
+}
+
+// readStatus returns the status the viewer sees of a message they sent to the user.
+// Read shows as delivered when the user hides read receipts from the viewer.
+func (v privacyView) readStatus(status string) string {
+	if status == "read" && !v.settings.ReadReceipts.Allows(v.isContact) {
+		return "delivered"
+	}
+	return status
+}
+
+// userResponses converts users to responses as the viewer sees them. Every handler
+// returning other users' profiles builds them here, so their privacy settings apply.
+func userResponses(viewerID string, users []models.User) []models.UserResponse {
+	userIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.ID != viewerID {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+
+	views := loadPrivacyViews(viewerID, userIDs)
+
+	responses := make([]models.UserResponse, len(users))
+	for i, user := range users {
+		// The viewer has no view of themselves and sees everything
+		view := views[user.ID]
+		user.Privacy = view.settings
+		responses[i] = user.ToResponse(view.isContact)
+	}
+
+	return responses
+}
+
+// userResponse converts a user to a response as the viewer sees it
+func userResponse(viewerID string, user models.User) models.UserResponse {
+	return userResponses(viewerID, []models.User{user})[0]
+}
+
+// applyMessagePrivacy hides what others in a conversation do not share with the
+// viewer: the avatars senders hide, and whether the receiver of a direct message
+// the viewer sent read it
+func applyMessagePrivacy(messages []models.MessageWithSender, viewerID string) {
+	userIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		if message.Sender.ID != viewerID {
+			userIDs = append(userIDs, message.Sender.ID)
+		} else if message.ReceiverID != nil && *message.ReceiverID != viewerID {
+			userIDs = append(userIDs, *message.ReceiverID)
+		}
+	}
+
+	views := loadPrivacyViews(viewerID, userIDs)
+	for i := range messages {
+		message := &messages[i]
+
+		if message.Sender.ID != viewerID {
+			if view := views[message.Sender.ID]; !view.settings.Avatar.Allows(view.isContact) {
+				message.Sender.Avatar = nil
+			}
+			continue
+		}
+
+		if message.ReceiverID != nil && *message.ReceiverID != viewerID {
+			message.Status = views[*message.ReceiverID].readStatus(message.Status)
+		}
+	}
+}
+
+// applyReadPrivacy shows a direct message the viewer sent as delivered, rather than
+// read, when its receiver hides read receipts from the viewer
+func applyReadPrivacy(message *models.Message, viewerID string) {
+	if message.SenderID != viewerID || message.ReceiverID == nil || *message.ReceiverID == viewerID {
+		return
+	}
+
+	if message.Status == "read" && !sharesReadReceipts(*message.ReceiverID, viewerID) {
+		message.Status = "delivered"
+	}
+}
+
+// sharesReadReceipts reports whether a reader lets a sender know they read their messages
+func sharesReadReceipts(readerID, senderID string) bool {
+	var audience models.Audience
+	var isContact bool
+
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT u.privacy_read_receipts,
+			EXISTS(SELECT 1 FROM contacts WHERE user_id = u.id AND contact_id = $2)
+		FROM users u WHERE u.id = $1
+	`, readerID, senderID).Scan(&audience, &isContact)
+
+	if err != nil {
+		log.Printf("Failed to load read receipt settings: %v", err)
+		return false
+	}
+
+	return audience.Allows(isContact)
+}
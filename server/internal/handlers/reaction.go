@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+	ws "ngabarin/server/internal/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxEmojiLength is the maximum size of a reaction emoji in bytes
+const MaxEmojiLength = 32
+
+// ReactionRequest represents add reaction request body
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// AddReaction adds an emoji reaction to a message
+func AddReaction(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+
+	var req ReactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	// Validate emoji
+	if !isValidEmoji(req.Emoji) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid emoji",
+		})
+	}
+
+	// Check if user can see the message
+	message, err := getReactableMessage(messageID, userID)
+	if err != nil {
+		return reactionMessageError(c, err)
+	}
+
+	// Add reaction (adding the same emoji twice is a no-op)
+	result, err := database.Pool.Exec(context.Background(), `
+		INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`, message.ID, userID, req.Emoji, time.Now())
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to add reaction",
+		})
+	}
+
+	if result.RowsAffected() > 0 {
+		broadcastReaction(message, ws.EventReactionAdded, userID, req.Emoji)
+	}
+
+	reactions, err := getReactionSummaries([]string{message.ID}, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"messageId": message.ID,
+			"reactions": reactionsOrEmpty(reactions[message.ID]),
+		},
+	})
+}
+
+// RemoveReaction removes the current user's emoji reaction from a message
+func RemoveReaction(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	messageID := c.Params("messageId")
+	emoji := c.Query("emoji")
+
+	// Validate emoji
+	if !isValidEmoji(emoji) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid emoji",
+		})
+	}
+
+	// Check if user can see the message
+	message, err := getReactableMessage(messageID, userID)
+	if err != nil {
+		return reactionMessageError(c, err)
+	}
+
+	// Remove reaction
+	result, err := database.Pool.Exec(context.Background(), `
+		DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, message.ID, userID, emoji)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to remove reaction",
+		})
+	}
+
+	if result.RowsAffected() == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Reaction not found",
+		})
+	}
+
+	broadcastReaction(message, ws.EventReactionRemoved, userID, emoji)
+
+	reactions, err := getReactionSummaries([]string{message.ID}, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"messageId": message.ID,
+			"reactions": reactionsOrEmpty(reactions[message.ID]),
+		},
+	})
+}
+
+// errMessageDeleted is returned when reacting to a message deleted for everyone
+var errMessageDeleted = errors.New("message has been deleted")
+
+// getReactableMessage returns a message the user has access to and that is not deleted
+func getReactableMessage(messageID, userID string) (models.Message, error) {
+	message, err := getAccessibleMessage(messageID, userID)
+	if err != nil {
+		return message, err
+	}
+
+	if message.DeletedAt != nil {
+		return message, errMessageDeleted
+	}
+
+	return message, nil
+}
+
+// reactionMessageError writes the response for an error returned by getReactableMessage
+func reactionMessageError(c *fiber.Ctx, err error) error {
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Message not found",
+		})
+	}
+
+	if err == errMessageDeleted {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Cannot react to a deleted message",
+		})
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"success": false,
+		"error":   "Database error",
+	})
+}
+
+// broadcastReaction notifies the DM peer or group members about a reaction change
+func broadcastReaction(message models.Message, eventType ws.EventType, userID, emoji string) {
+	payload := ws.ReactionPayload{
+		MessageID: message.ID,
+		UserID:    userID,
+		Emoji:     emoji,
+	}
+	if message.GroupID != nil {
+		payload.GroupID = *message.GroupID
+	} else {
+		payload.ChatID = userID // For the other participant, the chatId is the reacting user's ID
+	}
+
+	broadcastMessageEvent(message, ws.WSMessage{
+		Type:      eventType,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}, userID)
+}
+
+// emojiRanges are the code points an emoji can start with
+var emojiRanges = []struct{ lo, hi rune }{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, // © ®
+	{0x203C, 0x203C}, {0x2049, 0x2049}, // ‼ ⁉
+	{0x2122, 0x2122}, {0x2139, 0x2139}, // ™ ℹ
+	{0x2194, 0x21AA},                   // Arrows
+	{0x231A, 0x23FF},                   // Watch, hourglass, media controls
+	{0x24C2, 0x24C2},                   // Ⓜ
+	{0x25AA, 0x25FE},                   // Geometric shapes
+	{0x2600, 0x27BF},                   // Miscellaneous symbols and dingbats
+	{0x2934, 0x2935},                   // ⤴ ⤵
+	{0x2B05, 0x2B55},                   // ⬅ ⭐ ⭕
+	{0x3030, 0x3030}, {0x303D, 0x303D}, // 〰 〽
+	{0x3297, 0x3297}, {0x3299, 0x3299}, // ㊗ ㊙
+	{0x1F000, 0x1F1E5}, // Mahjong, playing cards, enclosed alphanumerics
+	{0x1F200, 0x1F2FF}, // Enclosed ideographs
+	{0x1F300, 0x1FAFF}, // Pictographs, emoticons, transport, supplemental symbols
+}
+
+const (
+	zeroWidthJoiner   = '\u200D'
+	emojiPresentation = '\uFE0F' // Variation selector 16
+	keycapMark        = '\u20E3'
+)
+
+// isValidEmoji checks that a reaction is a single emoji: one pictograph, optionally with
+// a skin tone, or a ZWJ, flag, keycap or tag sequence of them
+func isValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > MaxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	runes := []rune(emoji)
+
+	// Country flags are a pair of regional indicators
+	if len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) {
+		return true
+	}
+
+	// Keycaps are a digit, # or * enclosed by the keycap mark
+	if n := len(runes); n >= 2 && runes[n-1] == keycapMark {
+		return strings.ContainsRune("0123456789#*", runes[0]) &&
+			(n == 2 || (n == 3 && runes[1] == emojiPresentation))
+	}
+
+	// Pictographs joined by zero width joiners, each optionally followed by the emoji
+	// presentation selector, a skin tone or tag characters (subdivision flags)
+	expectBase := true
+	for _, r := range runes {
+		switch {
+		case expectBase:
+			if !isEmojiBase(r) {
+				return false
+			}
+			expectBase = false
+		case r == zeroWidthJoiner:
+			expectBase = true
+		case r == emojiPresentation, r >= 0x1F3FB && r <= 0x1F3FF, r >= 0xE0020 && r <= 0xE007F:
+		default:
+			return false
+		}
+	}
+
+	return !expectBase
+}
+
+// isEmojiBase reports whether r can start an emoji
+func isEmojiBase(r rune) bool {
+	for _, span := range emojiRanges {
+		if r >= span.lo && r <= span.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// isRegionalIndicator reports whether r is one of the letters flags are made of
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// getReactionSummaries returns aggregated reactions per message ID for the given messages
+func getReactionSummaries(messageIDs []string, userID string) (map[string][]models.ReactionSummary, error) {
+	summaries := make(map[string][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at) ASC
+	`, messageIDs, userID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var summary models.ReactionSummary
+
+		if err := rows.Scan(&messageID, &summary.Emoji, &summary.Count, &summary.ReactedByMe); err != nil {
+			continue
+		}
+
+		summaries[messageID] = append(summaries[messageID], summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+// attachReactions fills in the reactions of each message
+func attachReactions(messages []models.MessageWithSender, userID string) error {
+	messageIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.ID)
+	}
+
+	summaries, err := getReactionSummaries(messageIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactionsOrEmpty(summaries[messages[i].ID])
+	}
+
+	return nil
+}
+
+// reactionsOrEmpty returns an empty slice instead of nil so it is encoded as []
+func reactionsOrEmpty(reactions []models.ReactionSummary) []models.ReactionSummary {
+	if reactions == nil {
+		return []models.ReactionSummary{}
+	}
+	return reactions
+}
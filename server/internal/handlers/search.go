@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Private use characters ts_headline puts around matches. Snippets are HTML-escaped
+// before they are turned into <mark> tags, so message content cannot inject markup.
+const (
+	searchMatchStart = "\uE000"
+	searchMatchStop  = "\uE001"
+)
+
+// searchHeadlineOptions configures ts_headline snippets
+const searchHeadlineOptions = "StartSel=" + searchMatchStart + ", StopSel=" + searchMatchStop + ", MaxWords=20, MinWords=5, MaxFragments=2"
+
+// searchMatchMarks turns the match markers into <mark> tags
+var searchMatchMarks = strings.NewReplacer(searchMatchStart, "<mark>", searchMatchStop, "</mark>")
+
+// MessageSearchHit represents a single message search result
+type MessageSearchHit struct {
+	Message models.MessageWithSender `json:"message"`
+	Snippet string                   `json:"snippet"` // HTML-escaped, matching terms wrapped in <mark></mark>
+	Chat    SearchChatContext        `json:"chat"`
+	Cursor  string                   `json:"cursor"`
+}
+
+// SearchChatContext describes the DM or group a search hit belongs to
+type SearchChatContext struct {
+	Type   string  `json:"type"` // direct, group
+	ID     string  `json:"id"`   // Other user's ID for direct chats, group ID for groups
+	Name   string  `json:"name"`
+	Avatar *string `json:"avatar,omitempty"`
+}
+
+// rowWithExtras scans additional columns selected after the standard ones
+type rowWithExtras struct {
+	pgx.Row
+	extra []interface{}
+}
+
+// Scan scans the standard destinations followed by the extra ones
+func (r rowWithExtras) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, r.extra...)...)
+}
+
+// SearchMessages searches message content in the user's DMs and current groups
+func SearchMessages(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	query := c.Query("q", "")
+
+	if query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Search query is required",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	// Only messages from DMs the user took part in and groups they currently belong to
+	sql := `
+		SELECT ` + messageWithSenderColumns + `,
+			ts_headline('simple', m.content, query, '` + searchHeadlineOptions + `'),
+			peer.id, peer.name, peer.avatar, g.name, g.icon
+		FROM ` + messageWithSenderJoins + `
+		LEFT JOIN users peer ON m.group_id IS NULL
+			AND peer.id = CASE WHEN m.sender_id = $1 THEN m.receiver_id ELSE m.sender_id END
+		LEFT JOIN groups g ON m.group_id = g.id
+		CROSS JOIN websearch_to_tsquery('simple', $2) AS query
+		WHERE m.content_tsv @@ query
+		AND m.deleted_at IS NULL
+		AND ` + notHiddenCondition + `
+		AND (
+			(m.group_id IS NULL AND (m.sender_id = $1 OR m.receiver_id = $1))
+			OR EXISTS(SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = $1)
+		)`
+	args := []interface{}{userID, query}
+	argCount := 3
+
+	// Optional filters. Only text messages are indexed, so text is the only type
+	// that can match and needs no condition of its own.
+	if msgType := c.Query("type"); msgType != "" && msgType != "text" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid type. Only text messages are searchable",
+		})
+	}
+
+	if senderID := c.Query("senderId"); senderID != "" {
+		if _, err := uuid.Parse(senderID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid sender ID",
+			})
+		}
+		sql += " AND m.sender_id = $" + strconv.Itoa(argCount)
+		args = append(args, senderID)
+		argCount++
+	}
+
+	if chatID := c.Query("chatId"); chatID != "" {
+		if _, err := uuid.Parse(chatID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid chat ID",
+			})
+		}
+		param := "$" + strconv.Itoa(argCount)
+		sql += " AND ((m.sender_id = $1 AND m.receiver_id = " + param + ") OR (m.sender_id = " + param + " AND m.receiver_id = $1))"
+		args = append(args, chatID)
+		argCount++
+	}
+
+	if groupID := c.Query("groupId"); groupID != "" {
+		if _, err := uuid.Parse(groupID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid group ID",
+			})
+		}
+		sql += " AND m.group_id = $" + strconv.Itoa(argCount)
+		args = append(args, groupID)
+		argCount++
+	}
+
+	if from := c.Query("from"); from != "" {
+		fromTime, err := parseSearchDate(from, false)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid from date. Use RFC3339 or YYYY-MM-DD",
+			})
+		}
+		sql += " AND m.created_at >= $" + strconv.Itoa(argCount)
+		args = append(args, fromTime)
+		argCount++
+	}
+
+	if to := c.Query("to"); to != "" {
+		toTime, err := parseSearchDate(to, true)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid to date. Use RFC3339 or YYYY-MM-DD",
+			})
+		}
+		sql += " AND m.created_at < $" + strconv.Itoa(argCount)
+		args = append(args, toTime)
+		argCount++
+	}
+
+	// Continue after the last hit of the previous page
+	if cursorValue := c.Query("cursor"); cursorValue != "" {
+		cursor, err := decodeMessageCursor(cursorValue)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid cursor",
+			})
+		}
+		sql += " AND (m.created_at, m.id) < ($" + strconv.Itoa(argCount) + ", $" + strconv.Itoa(argCount+1) + ")"
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	}
+
+	sql += " ORDER BY m.created_at DESC, m.id DESC LIMIT " + strconv.Itoa(limit+1)
+
+	rows, err := database.Pool.Query(context.Background(), sql, args...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	var hits []MessageSearchHit
+
+	for rows.Next() {
+		var snippet string
+		var peerID, peerName, peerAvatar *string
+		var groupName, groupIcon *string
+
+		message, err := scanMessageWithSender(rowWithExtras{
+			Row:   rows,
+			extra: []interface{}{&snippet, &peerID, &peerName, &peerAvatar, &groupName, &groupIcon},
+		})
+
+		if err != nil {
+			continue
+		}
+
+		hit := MessageSearchHit{
+			Message: message,
+			Snippet: highlightSnippet(snippet),
+			Cursor:  encodeMessageCursor(message.CreatedAt, message.ID),
+		}
+
+		if message.GroupID != nil && groupName != nil {
+			hit.Chat = SearchChatContext{Type: "group", ID: *message.GroupID, Name: *groupName, Avatar: groupIcon}
+		} else if peerID != nil && peerName != nil {
+			hit.Chat = SearchChatContext{Type: "direct", ID: *peerID, Name: *peerName, Avatar: peerAvatar}
+		}
+
+		hits = append(hits, hit)
+	}
+
+	if rows.Err() != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	// The extra row only tells us whether there is another page
+	var nextCursor *string
+	if len(hits) > limit {
+		hits = hits[:limit]
+		nextCursor = &hits[limit-1].Cursor
+	}
+
+	if hits == nil {
+		hits = []MessageSearchHit{}
+	}
+
+	messages := make([]models.MessageWithSender, len(hits))
+	for i := range hits {
+		messages[i] = hits[i].Message
+	}
+
+	if err := attachReactions(messages, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	applyMessagePrivacy(messages, userID)
+
+	// Direct chats show the other user's avatar only if they share it with the viewer
+	var peers []models.User
+	var peerHits []int
+	for i := range hits {
+		hits[i].Message = messages[i]
+
+		if hits[i].Chat.Type == "direct" {
+			peers = append(peers, models.User{ID: hits[i].Chat.ID, Avatar: hits[i].Chat.Avatar})
+			peerHits = append(peerHits, i)
+		}
+	}
+
+	for k, peer := range userResponses(userID, peers) {
+		hits[peerHits[k]].Chat.Avatar = peer.Avatar
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"results": hits,
+			"pagination": fiber.Map{
+				"limit":      limit,
+				"nextCursor": nextCursor,
+			},
+		},
+	})
+}
+
+// parseSearchDate parses an RFC3339 timestamp or a YYYY-MM-DD date.
+// For end dates, a plain date covers the whole day.
+func parseSearchDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if endOfDay {
+		t = t.Add(24 * time.Hour)
+	}
+
+	return t, nil
+}
+
+// highlightSnippet escapes a ts_headline snippet for HTML and marks its matching terms
+func highlightSnippet(headline string) string {
+	return searchMatchMarks.Replace(html.EscapeString(headline))
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+	"ngabarin/server/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// maxUserAgentLength is how much of the User-Agent header is kept for the sessions list
+	maxUserAgentLength = 512
+
+	// refreshReuseGrace is how long after a rotation the old refresh token is refused
+	// without revoking the session, so two tabs refreshing at once do not log the user out
+	refreshReuseGrace = 10 * time.Second
+
+	// maxSecurityEvents is how many of the latest security events are listed
+	maxSecurityEvents = 50
+)
+
+// startSession records a new login session for the request's device and sets its token cookies
+func startSession(c *fiber.Ctx, user *models.User) error {
+	userAgent := requestUserAgent(c)
+
+	var sessionID string
+	err := database.Pool.QueryRow(context.Background(), `
+		INSERT INTO sessions (user_id, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING id
+	`, user.ID, c.IP(), userAgent, time.Now().Add(utils.RefreshTokenTTL)).Scan(&sessionID)
+
+	if err != nil {
+		return err
+	}
+
+	token, refreshToken, err := createTokens(database.Pool, user.ID, user.Email, user.UniqueID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	setAuthCookies(c, token, refreshToken)
+	return nil
+}
+
+// rowQuerier is the pool or a transaction
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// createTokens generates an access token for a session and the next refresh token
+// in its rotation family, recording the refresh token so its reuse can be detected
+func createTokens(db rowQuerier, userID, email, uniqueID, sessionID string) (token, refreshToken string, err error) {
+	var tokenID string
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO refresh_tokens (session_id, expires_at) VALUES ($1, $2) RETURNING id
+	`, sessionID, time.Now().Add(utils.RefreshTokenTTL)).Scan(&tokenID)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err = utils.GenerateToken(userID, email, uniqueID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = utils.GenerateRefreshToken(userID, email, uniqueID, sessionID, tokenID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, refreshToken, nil
+}
+
+// setAuthCookies sets the access and refresh token cookies
+func setAuthCookies(c *fiber.Ctx, token, refreshToken string) {
+	// Set HTTP-Only Cookie for access token
+	c.Cookie(&fiber.Cookie{
+		Name:     "token",
+		Value:    token,
+		HTTPOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: "Lax",
+		MaxAge:   int(utils.AccessTokenTTL.Seconds()),
+	})
+
+	// Set HTTP-Only Cookie for refresh token
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		HTTPOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: "Lax",
+		MaxAge:   int(utils.RefreshTokenTTL.Seconds()),
+	})
+}
+
+// clearAuthCookies deletes the token cookies
+func clearAuthCookies(c *fiber.Ctx) {
+	for _, name := range []string{"token", "refresh_token"} {
+		c.Cookie(&fiber.Cookie{
+			Name:     name,
+			Value:    "",
+			HTTPOnly: true,
+			Secure:   false,
+			SameSite: "Lax",
+			MaxAge:   -1, // Delete cookie
+		})
+	}
+}
+
+// revokeSessions revokes the given sessions of a user, or all of them when sessionIDs is nil
+// (except keepSessionID), and closes their realtime connections. It returns the revoked IDs.
+func revokeSessions(userID string, sessionIDs []string, keepSessionID string) ([]string, error) {
+	rows, err := database.Pool.Query(context.Background(), `
+		UPDATE sessions SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+		AND ($3::uuid[] IS NULL OR id = ANY($3::uuid[]))
+		AND ($4 = '' OR id::text <> $4)
+		RETURNING id
+	`, time.Now(), userID, sessionIDs, keepSessionID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			continue
+		}
+		revoked = append(revoked, sessionID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if WSHub != nil {
+		WSHub.CloseSessions(userID, revoked)
+	}
+
+	return revoked, nil
+}
+
+// GetSessions lists the current user's active sessions, most recently active first
+func GetSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	currentSessionID := c.Locals("sessionID").(string)
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT id, ip_address, user_agent, created_at, last_active_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_active_at DESC
+	`, userID, time.Now())
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastActiveAt, &session.ExpiresAt); err != nil {
+			continue
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// RevokeSession logs one of the current user's sessions out and closes its connections
+func RevokeSession(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	currentSessionID := c.Locals("sessionID").(string)
+	sessionID := c.Params("sessionId")
+
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid session ID",
+		})
+	}
+
+	revoked, err := revokeSessions(userID, []string{sessionID}, "")
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to revoke session",
+		})
+	}
+
+	if len(revoked) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Session not found",
+		})
+	}
+
+	if sessionID == currentSessionID {
+		clearAuthCookies(c)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Session revoked successfully",
+	})
+}
+
+// RevokeAllSessions logs the current user out everywhere. With ?keepCurrent=true
+// the session making the request stays logged in.
+func RevokeAllSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	currentSessionID := c.Locals("sessionID").(string)
+
+	keepSessionID := ""
+	if c.QueryBool("keepCurrent") {
+		keepSessionID = currentSessionID
+	}
+
+	revoked, err := revokeSessions(userID, nil, keepSessionID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to revoke sessions",
+		})
+	}
+
+	if keepSessionID == "" {
+		clearAuthCookies(c)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"revoked": len(revoked),
+		},
+	})
+}
+
+// requestUserAgent returns the request's User-Agent header, truncated for storage
+func requestUserAgent(c *fiber.Ctx) string {
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// recordSecurityEvent stores a security alert for a user and logs it
+func recordSecurityEvent(c *fiber.Ctx, userID string, eventType models.SecurityEventType, sessionID string) {
+	log.Printf("SECURITY ALERT: %s for user %s (session %s, ip %s)", eventType, userID, sessionID, c.IP())
+
+	_, err := database.Pool.Exec(context.Background(), `
+		INSERT INTO security_events (user_id, type, session_id, ip_address, user_agent)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, NULLIF($5, ''))
+	`, userID, eventType, sessionID, c.IP(), requestUserAgent(c))
+
+	if err != nil {
+		log.Printf("Failed to record security event for %s: %v", userID, err)
+	}
+}
+
+// GetSecurityEvents lists the latest security alerts for the current user
+func GetSecurityEvents(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT id, type, session_id, ip_address, user_agent, created_at
+		FROM security_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, maxSecurityEvents)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer rows.Close()
+
+	events := []models.SecurityEvent{}
+	for rows.Next() {
+		var event models.SecurityEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.SessionID, &event.IPAddress,
+			&event.UserAgent, &event.CreatedAt); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    events,
+	})
+}
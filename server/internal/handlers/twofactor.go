@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+	"ngabarin/server/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps
+	totpIssuer = "Ngabarin"
+
+	// recoveryCodeCount is how many recovery codes are issued when 2FA is enabled
+	recoveryCodeCount = 10
+
+	// maxSecondFactorAttempts is how many codes may be entered before the account is locked
+	maxSecondFactorAttempts = 5
+
+	// secondFactorLockout is how long an account refuses codes after too many wrong ones
+	secondFactorLockout = 15 * time.Minute
+)
+
+// errSecondFactorLocked is returned while an account refuses codes after too many wrong ones
+var errSecondFactorLocked = errors.New("too many invalid codes")
+
+// TwoFactorCodeRequest represents the body of POST /auth/2fa/confirm
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// VerifyTwoFactorRequest represents the second login step: the token Login returned
+// and either a TOTP code or a recovery code
+type VerifyTwoFactorRequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// DisableTwoFactorRequest re-authenticates the user with their password and a second factor
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// GetTwoFactorStatus reports whether 2FA is enabled and how many recovery codes are left
+func GetTwoFactorStatus(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var enabledAt *time.Time
+	var recoveryCodes int
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT totp_enabled_at,
+			(SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		FROM users WHERE id = $1
+	`, userID).Scan(&enabledAt, &recoveryCodes)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"enabled":                enabledAt != nil,
+			"enabledAt":              enabledAt,
+			"recoveryCodesRemaining": recoveryCodes,
+		},
+	})
+}
+
+// SetupTwoFactor starts TOTP enrolment and returns the secret as an otpauth:// URI.
+// 2FA is enabled once the user confirms a code from their authenticator app.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var email, authProvider string
+	var enabledAt *time.Time
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT email, auth_provider, totp_enabled_at FROM users WHERE id = $1
+	`, userID).Scan(&email, &authProvider, &enabledAt)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if authProvider != "email" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Two-factor authentication is only available for email accounts",
+		})
+	}
+
+	if enabledAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "Two-factor authentication is already enabled",
+		})
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate secret",
+		})
+	}
+
+	_, err = database.Pool.Exec(context.Background(), `
+		UPDATE users SET totp_pending_secret = $1, updated_at = $2 WHERE id = $3
+	`, secret, time.Now(), userID)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to start two-factor setup",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"secret":     secret,
+			"otpauthUri": utils.TOTPURI(totpIssuer, email, secret),
+		},
+	})
+}
+
+// ConfirmTwoFactor enables 2FA with the secret from SetupTwoFactor once the user
+// enters a valid code, and returns the recovery codes (shown only this once)
+func ConfirmTwoFactor(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Code is required",
+		})
+	}
+
+	var pendingSecret *string
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT totp_pending_secret FROM users WHERE id = $1 AND totp_enabled_at IS NULL
+	`, userID).Scan(&pendingSecret)
+
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "Two-factor authentication is already enabled",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if pendingSecret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Start two-factor setup first",
+		})
+	}
+
+	step, ok := utils.ValidateTOTP(*pendingSecret, strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid code",
+		})
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate recovery codes",
+		})
+	}
+
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	// The code used to confirm cannot be replayed to log in
+	tag, err := tx.Exec(context.Background(), `
+		UPDATE users
+		SET totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+			totp_enabled_at = $1, totp_last_step = $2, updated_at = $1
+		WHERE id = $3 AND totp_enabled_at IS NULL AND totp_pending_secret = $4
+	`, time.Now(), step, userID, *pendingSecret)
+
+	if err == nil && tag.RowsAffected() == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success": false,
+			"error":   "Two-factor setup changed, please start again",
+		})
+	}
+
+	if err == nil {
+		err = replaceRecoveryCodes(tx, userID, hashes)
+	}
+
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
+
+	if err != nil {
+		log.Printf("Failed to enable two-factor authentication for %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to enable two-factor authentication",
+		})
+	}
+
+	recordSecurityEvent(c, userID, models.SecurityEventTwoFactorEnabled, c.Locals("sessionID").(string))
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data": fiber.Map{
+			"recoveryCodes": codes,
+		},
+	})
+}
+
+// VerifyTwoFactor completes a login that Login answered with mfaRequired, starting
+// the session once the TOTP or recovery code checks out
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	var req VerifyTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "mfaToken and a code or recovery code are required",
+		})
+	}
+
+	claims, err := utils.ValidateToken(req.MFAToken)
+	if err != nil || claims.Type != "mfa_pending" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid or expired login, please sign in again",
+		})
+	}
+
+	var user models.User
+	var secret *string
+	err = database.Pool.QueryRow(context.Background(), `
+		SELECT id, unique_id, email, name, avatar, auth_provider, is_online, presence_status, status_text, status_expires_at, last_seen, created_at, updated_at,
+			totp_secret
+		FROM users WHERE id = $1
+	`, claims.UserID).Scan(&user.ID, &user.UniqueID, &user.Email, &user.Name,
+		&user.Avatar, &user.AuthProvider, &user.IsOnline, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen, &user.CreatedAt, &user.UpdatedAt,
+		&secret)
+
+	if err == pgx.ErrNoRows {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid or expired login, please sign in again",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	// 2FA was turned off in the meantime; the password step already succeeded
+	if secret != nil {
+		usedRecovery, ok, err := checkSecondFactor(user.ID, *secret, req.Code, req.RecoveryCode)
+		if err == errSecondFactorLocked {
+			return secondFactorLocked(c)
+		}
+
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid code",
+			})
+		}
+
+		if usedRecovery {
+			recordSecurityEvent(c, user.ID, models.SecurityEventRecoveryCodeUsed, "")
+		}
+	}
+
+	return completeLogin(c, &user)
+}
+
+// DisableTwoFactor turns 2FA off after the user re-enters their password and a second factor
+func DisableTwoFactor(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req DisableTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if req.Password == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Password and a code or recovery code are required",
+		})
+	}
+
+	var passwordHash, secret *string
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT password_hash, totp_secret FROM users WHERE id = $1
+	`, userID).Scan(&passwordHash, &secret)
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if secret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Two-factor authentication is not enabled",
+		})
+	}
+
+	if passwordHash == nil || !utils.CheckPassword(*passwordHash, req.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid password",
+		})
+	}
+
+	_, ok, err := checkSecondFactor(userID, *secret, req.Code, req.RecoveryCode)
+	if err == errSecondFactorLocked {
+		return secondFactorLocked(c)
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid code",
+		})
+	}
+
+	tx, err := database.Pool.Begin(context.Background())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), `
+		UPDATE users
+		SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL,
+			totp_last_step = NULL, totp_failed_attempts = 0, totp_locked_until = NULL, updated_at = $1
+		WHERE id = $2
+	`, time.Now(), userID)
+
+	if err == nil {
+		err = replaceRecoveryCodes(tx, userID, nil)
+	}
+
+	if err == nil {
+		err = tx.Commit(context.Background())
+	}
+
+	if err != nil {
+		log.Printf("Failed to disable two-factor authentication for %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to disable two-factor authentication",
+		})
+	}
+
+	recordSecurityEvent(c, userID, models.SecurityEventTwoFactorDisabled, c.Locals("sessionID").(string))
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// secondFactorLocked responds to a code entered while the account is locked
+func secondFactorLocked(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"success": false,
+		"error":   "Too many invalid codes, try again later",
+	})
+}
+
+// checkSecondFactor checks a TOTP code, or else a recovery code, and uses it up.
+// Every attempt counts towards maxSecondFactorAttempts until a code is accepted;
+// after that many the account returns errSecondFactorLocked for secondFactorLockout.
+func checkSecondFactor(userID, secret, code, recoveryCode string) (usedRecovery, ok bool, err error) {
+	if err := countSecondFactorAttempt(userID); err != nil {
+		return false, false, err
+	}
+
+	usedRecovery, ok, err = matchSecondFactor(userID, secret, code, recoveryCode)
+	if err != nil || !ok {
+		return usedRecovery, ok, err
+	}
+
+	_, err = database.Pool.Exec(context.Background(), `
+		UPDATE users SET totp_failed_attempts = 0, totp_locked_until = NULL WHERE id = $1
+	`, userID)
+
+	return usedRecovery, ok, err
+}
+
+// countSecondFactorAttempt counts an attempt before its code is checked, so guesses
+// sent in parallel count too. The attempt that uses up the last one locks the
+// account unless its code is accepted. A lockout that has passed starts a new count.
+func countSecondFactorAttempt(userID string) error {
+	now := time.Now()
+
+	tag, err := database.Pool.Exec(context.Background(), `
+		UPDATE users SET
+			totp_failed_attempts = CASE WHEN totp_locked_until IS NULL THEN totp_failed_attempts + 1 ELSE 1 END,
+			totp_locked_until = CASE
+				WHEN totp_locked_until IS NULL AND totp_failed_attempts + 1 >= $1 THEN $2::timestamptz
+				ELSE NULL
+			END
+		WHERE id = $3 AND (totp_locked_until IS NULL OR totp_locked_until <= $4)
+	`, maxSecondFactorAttempts, now.Add(secondFactorLockout), userID, now)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errSecondFactorLocked
+	}
+
+	return nil
+}
+
+// matchSecondFactor checks a TOTP code, or else a recovery code, and uses it up.
+// A TOTP code is accepted once; a recovery code is marked used.
+func matchSecondFactor(userID, secret, code, recoveryCode string) (usedRecovery, ok bool, err error) {
+	if code != "" {
+		step, valid := utils.ValidateTOTP(secret, strings.TrimSpace(code), time.Now())
+		if !valid {
+			return false, false, nil
+		}
+
+		tag, err := database.Pool.Exec(context.Background(), `
+			UPDATE users SET totp_last_step = $1
+			WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)
+		`, step, userID)
+
+		if err != nil {
+			return false, false, err
+		}
+
+		return false, tag.RowsAffected() == 1, nil
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT id, code_hash FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+
+	if err != nil {
+		return false, false, err
+	}
+
+	normalized := utils.NormalizeRecoveryCode(recoveryCode)
+	var matchID string
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			continue
+		}
+		if utils.CheckPassword(hash, normalized) {
+			matchID = id
+			break
+		}
+	}
+	rows.Close()
+
+	if matchID == "" {
+		return false, false, nil
+	}
+
+	tag, err := database.Pool.Exec(context.Background(), `
+		UPDATE recovery_codes SET used_at = $1 WHERE id = $2 AND used_at IS NULL
+	`, time.Now(), matchID)
+
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, tag.RowsAffected() == 1, nil
+}
+
+// newRecoveryCodes generates recovery codes and their bcrypt hashes
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		if hashes[i], err = utils.HashPassword(code); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return codes, hashes, nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores the given hashes instead
+func replaceRecoveryCodes(tx pgx.Tx, userID string, hashes []string) error {
+	_, err := tx.Exec(context.Background(), "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
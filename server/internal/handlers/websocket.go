@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"log"
+	"os"
+	"strconv"
+	"time"
 
+	"ngabarin/server/internal/database"
 	ws "ngabarin/server/internal/websocket"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 var (
@@ -14,13 +20,137 @@ var (
 	WSHub *ws.Hub
 )
 
+// MaxDeviceIDLength is the maximum length of a client-supplied device ID
+const MaxDeviceIDLength = 100
+
 // InitWebSocket initializes the WebSocket hub
 func InitWebSocket() {
 	WSHub = ws.NewHub()
+	WSHub.Messages = wsMessageService{}
+
+	if retention, err := time.ParseDuration(os.Getenv("EVENT_LOG_RETENTION")); err == nil && retention > 0 {
+		WSHub.EventRetention = retention
+	}
+
+	// Outbound queue limits for slow clients
+	if size, err := strconv.Atoi(os.Getenv("WS_SEND_QUEUE_SIZE")); err == nil && size > 0 {
+		WSHub.SendQueueSize = size
+	}
+	WSHub.DropPolicy = ws.ParseDropPolicy(os.Getenv("WS_DROP_POLICY"))
+
+	// Inactivity reported by every device before a user goes idle
+	if idleAfter, err := time.ParseDuration(os.Getenv("PRESENCE_IDLE_AFTER")); err == nil && idleAfter > 0 {
+		WSHub.IdleAfter = idleAfter
+	}
+
+	// Fan events out to other replicas
+	switch os.Getenv("WS_BROKER") {
+	case "redis":
+		if database.Redis == nil {
+			log.Fatal("WS_BROKER=redis requires REDIS_URL")
+		}
+		WSHub.Broker = ws.NewRedisBroker(database.Redis)
+		WSHub.Presence = ws.NewRedisPresence(database.Redis, WSHub.NodeID, ws.DefaultPresenceTTL)
+		log.Println("✅ WebSocket events and presence shared through Redis")
+	case "postgres":
+		WSHub.Broker = ws.NewPostgresBroker(database.Pool)
+		log.Println("✅ WebSocket events fanned out with Postgres LISTEN/NOTIFY")
+	default:
+		WSHub.Broker = ws.NewMemoryBroker()
+	}
+
 	go WSHub.Run()
+	go reapLongPollSessions()
 	log.Println("✅ WebSocket Hub initialized")
 }
 
+// ShutdownWebSocket closes every realtime client and marks their users offline
+func ShutdownWebSocket(ctx context.Context) error {
+	if WSHub == nil {
+		return nil
+	}
+	return WSHub.Shutdown(ctx)
+}
+
+// wsMessageService lets WebSocket clients send messages through the same path as the REST API
+type wsMessageService struct{}
+
+// SendDirectMessage stores a direct message sent over the WebSocket
+func (wsMessageService) SendDirectMessage(senderID string, frame ws.SendMessageFrame) (*ws.AckPayload, error) {
+	message, _, err := createDirectMessage(senderID, SendMessageRequest{
+		ReceiverID:      frame.ReceiverID,
+		Content:         frame.Content,
+		Type:            frame.Type,
+		ReplyToID:       frame.ReplyToID,
+		ClientMessageID: frame.ClientMessageID,
+	})
+	if err != nil {
+		return nil, toWSError(err)
+	}
+
+	return &ws.AckPayload{
+		MessageID: message.ID,
+		Status:    message.Status,
+		CreatedAt: message.CreatedAt,
+	}, nil
+}
+
+// SendGroupMessage stores a group message sent over the WebSocket
+func (wsMessageService) SendGroupMessage(senderID string, frame ws.SendMessageFrame) (*ws.AckPayload, error) {
+	message, _, err := createGroupMessage(senderID, SendGroupMessageRequest{
+		GroupID:         frame.GroupID,
+		Content:         frame.Content,
+		Type:            frame.Type,
+		ReplyToID:       frame.ReplyToID,
+		ClientMessageID: frame.ClientMessageID,
+	})
+	if err != nil {
+		return nil, toWSError(err)
+	}
+
+	return &ws.AckPayload{
+		MessageID: message.ID,
+		Status:    message.Status,
+		CreatedAt: message.CreatedAt,
+	}, nil
+}
+
+// toWSError converts an error from the shared send path into an error frame payload
+func toWSError(err error) error {
+	if reqErr, ok := err.(*requestError); ok {
+		return &ws.ErrorPayload{Code: reqErr.Code, Message: reqErr.Message}
+	}
+	return err
+}
+
+// authorizer returns the hub's authorizer, so REST and realtime sends follow the same rules
+func authorizer() ws.Authorizer {
+	if WSHub != nil && WSHub.Authorizer != nil {
+		return WSHub.Authorizer
+	}
+	return ws.DatabaseAuthorizer{}
+}
+
+// onlineStatuses returns the live online status of the given users, or nil when the
+// users.is_online column already is the source of truth
+func onlineStatuses(userIDs []string) map[string]bool {
+	if WSHub == nil || len(userIDs) == 0 {
+		return nil
+	}
+
+	if _, ok := WSHub.Presence.(ws.DatabasePresence); ok {
+		return nil
+	}
+
+	online, err := WSHub.OnlineUsers(userIDs)
+	if err != nil {
+		log.Printf("Failed to get online status: %v", err)
+		return nil
+	}
+
+	return online
+}
+
 // WebSocketUpgrade checks if the request should be upgraded to WebSocket
 func WebSocketUpgrade(c *fiber.Ctx) error {
 	// Check if this is a WebSocket upgrade request
@@ -30,7 +160,7 @@ func WebSocketUpgrade(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
 		"success": false,
-		"error":   "WebSocket upgrade required",
+		"error":   "WebSocket upgrade required. Use /api/v1/events (Server-Sent Events) or /api/v1/events/poll if WebSockets are unavailable",
 	})
 }
 
@@ -40,8 +170,21 @@ func WebSocketHandler(c *websocket.Conn) {
 	userID := c.Locals("userID").(string)
 	uniqueID := c.Locals("uniqueID").(string)
 
+	// Each device keeps its own connection; clients without a device ID get a fresh one
+	deviceID := c.Query("deviceId")
+	if deviceID == "" || len(deviceID) > MaxDeviceIDLength {
+		deviceID = uuid.New().String()
+	}
+
 	// Create new client
-	client := ws.NewClient(userID, uniqueID, c, WSHub)
+	client := ws.NewClient(userID, uniqueID, deviceID, c, WSHub)
+	client.SessionID = c.Locals("sessionID").(string)
+
+	// Frame encoding from the negotiated subprotocol or ?encoding=; only JSON frames are compressed
+	client.Encoding = ws.NegotiateEncoding(c.Subprotocol(), c.Query("encoding"))
+	c.EnableWriteCompression(client.Encoding == ws.EncodingJSON)
+
+	prepareClient(client, c.Query("since"))
 
 	// Register client
 	WSHub.Register <- client
@@ -51,6 +194,26 @@ func WebSocketHandler(c *websocket.Conn) {
 	client.ReadPump() // This blocks until connection closes
 }
 
+// prepareClient loads the user's name and the event sequence to resume from
+func prepareClient(client *ws.Client, since string) {
+	// Name shown in typing indicators
+	client.Name = getUserName(client.ID)
+
+	// Resume from the last event the client saw
+	if seq, err := strconv.ParseInt(since, 10, 64); err == nil && seq >= 0 {
+		client.Since = seq
+	}
+}
+
+// getUserName returns a user's display name, or an empty string if it cannot be loaded
+func getUserName(userID string) string {
+	var name string
+	if err := database.Pool.QueryRow(context.Background(), "SELECT name FROM users WHERE id = $1", userID).Scan(&name); err != nil {
+		log.Printf("Failed to load name for %s: %v", userID, err)
+	}
+	return name
+}
+
 // GetWebSocketStats returns WebSocket connection statistics
 func GetWebSocketStats(c *fiber.Ctx) error {
 	if WSHub == nil {
@@ -64,7 +227,9 @@ func GetWebSocketStats(c *fiber.Ctx) error {
 		"success": true,
 		"data": fiber.Map{
 			"onlineUsers": WSHub.GetOnlineCount(),
+			"connections": WSHub.GetConnectionCount(),
 			"userIds":     WSHub.GetOnlineUsers(),
+			"metrics":     WSHub.MetricsSnapshot(),
 		},
 	})
 }
@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Default is the mailer set up by Init
+var Default Mailer = LogMailer{}
+
+// Init sets up Default from MAIL_DRIVER: smtp, file (writes .eml files to MAIL_DIR)
+// or log (prints emails, the default for local development)
+func Init() error {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Ngabarin <no-reply@localhost>"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return fmt.Errorf("MAIL_DRIVER=smtp requires SMTP_HOST")
+		}
+
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+
+		Default = &SMTPMailer{
+			Addr:     net.JoinHostPort(host, port),
+			Host:     host,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+		log.Printf("✅ Mail sent through SMTP server %s", host)
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create MAIL_DIR: %w", err)
+		}
+
+		Default = FileMailer{Dir: dir, From: from}
+		log.Printf("✅ Mail written to %s", dir)
+	case "", "log":
+		Default = LogMailer{}
+	default:
+		return fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+
+	return nil
+}
+
+// Send sends a message with the default mailer
+func Send(ctx context.Context, message Message) error {
+	return Default.Send(ctx, message)
+}
+
+// SMTPMailer sends email through an SMTP server, upgrading to TLS when the server supports it
+type SMTPMailer struct {
+	Addr     string // host:port
+	Host     string
+	Username string // Empty to send without authentication
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server. The whole conversation, not just
+// the dial, gives up when ctx is done.
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(address(m.From)); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+
+	body, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := body.Write(format(m.From, message)); err != nil {
+		return err
+	}
+	if err := body.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// LogMailer prints emails instead of sending them
+type LogMailer struct{}
+
+// Send logs the message
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("📧 Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileMailer writes each email to an .eml file in Dir, for local development
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the message to a new file
+func (m FileMailer) Send(ctx context.Context, message Message) error {
+	name := time.Now().Format("20060102-150405") + "-" + uuid.New().String() + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, message), 0o644)
+}
+
+// format builds the RFC 5322 message
+func format(from string, message Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + headerValue(from) + "\r\n")
+	b.WriteString("To: " + headerValue(message.To) + "\r\n")
+	b.WriteString("Subject: " + headerValue(message.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue strips line breaks so a value cannot add headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// address returns the bare address of "Name <address>"
+func address(from string) string {
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		return parsed.Address
+	}
+	return from
+}
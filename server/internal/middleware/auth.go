@@ -18,16 +18,31 @@ func AuthMiddleware(c *fiber.Ctx) error {
 
 	// Validate token
 	claims, err := utils.ValidateToken(tokenString)
-	if err != nil {
+	if err != nil || claims.Type != "access" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Unauthorized - Invalid token",
 		})
 	}
 
+	// Tokens stop working as soon as their session is revoked
+	active, err := checkSession(claims.SessionID, claims.UserID, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify session",
+		})
+	}
+
+	if !active {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Session expired or revoked",
+		})
+	}
+
 	// Store user info in context
 	c.Locals("userID", claims.UserID)
 	c.Locals("email", claims.Email)
 	c.Locals("uniqueID", claims.UniqueID)
+	c.Locals("sessionID", claims.SessionID)
 
 	return c.Next()
 }
@@ -50,6 +65,15 @@ func GetUserEmail(c *fiber.Ctx) string {
 	return email
 }
 
+// GetSessionID gets the current session ID from context
+func GetSessionID(c *fiber.Ctx) string {
+	sessionID, ok := c.Locals("sessionID").(string)
+	if !ok {
+		return ""
+	}
+	return sessionID
+}
+
 // GetUniqueID gets unique ID from context
 func GetUniqueID(c *fiber.Ctx) string {
 	uniqueID, ok := c.Locals("uniqueID").(string)
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"ngabarin/server/internal/database"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// sessionActivityInterval limits how often a session's last activity is written
+const sessionActivityInterval = time.Minute
+
+// checkSession reports whether a session of the user is still active and records its activity
+func checkSession(sessionID, userID, ipAddress string) (bool, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+
+	var lastActive time.Time
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT last_active_at FROM sessions
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3
+	`, sessionID, userID, now).Scan(&lastActive)
+
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	// A failed write only leaves the session's last activity stale
+	if now.Sub(lastActive) >= sessionActivityInterval {
+		database.Pool.Exec(context.Background(), `
+			UPDATE sessions SET last_active_at = $1, ip_address = $2 WHERE id = $3
+		`, now, ipAddress, sessionID)
+	}
+
+	return true, nil
+}
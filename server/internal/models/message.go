@@ -4,26 +4,79 @@ import "time"
 
 // Message represents a chat message
 type Message struct {
-	ID         string    `json:"id" db:"id"`
-	SenderID   string    `json:"senderId" db:"sender_id"`
-	ReceiverID *string   `json:"receiverId,omitempty" db:"receiver_id"` // Null for group messages
-	GroupID    *string   `json:"groupId,omitempty" db:"group_id"`       // Null for direct messages
-	Content    string    `json:"content" db:"content"`
-	Type       string    `json:"type" db:"type"`     // 'text', 'image', 'file'
-	Status     string    `json:"status" db:"status"` // 'sent', 'delivered', 'read'
-	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
+	ID              string     `json:"id" db:"id"`
+	SenderID        string     `json:"senderId" db:"sender_id"`
+	ReceiverID      *string    `json:"receiverId,omitempty" db:"receiver_id"` // Null for group messages
+	GroupID         *string    `json:"groupId,omitempty" db:"group_id"`       // Null for direct messages
+	Content         string     `json:"content" db:"content"`
+	Type            string     `json:"type" db:"type"`                                   // 'text', 'image', 'file'
+	Status          string     `json:"status" db:"status"`                               // 'sent', 'delivered', 'read'
+	ReplyToID       *string    `json:"replyToId,omitempty" db:"reply_to_id"`             // Null if not a reply
+	ClientMessageID *string    `json:"clientMessageId,omitempty" db:"client_message_id"` // Sender-supplied idempotency key
+	EditedAt        *time.Time `json:"editedAt,omitempty" db:"edited_at"`                // Null if never edited
+	DeletedAt       *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`              // Set when deleted for everyone
+	CreatedAt       time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updatedAt" db:"updated_at"`
 }
 
 // MessageWithSender includes sender information
 type MessageWithSender struct {
-	ID         string       `json:"id"`
-	Sender     UserResponse `json:"sender"`
-	ReceiverID *string      `json:"receiverId,omitempty"`
-	GroupID    *string      `json:"groupId,omitempty"`
-	Content    string       `json:"content"`
-	Type       string       `json:"type"`
-	Status     string       `json:"status"`
-	CreatedAt  time.Time    `json:"createdAt"`
-	UpdatedAt  time.Time    `json:"updatedAt"`
+	ID         string            `json:"id"`
+	Sender     MessageSender     `json:"sender"`
+	ReceiverID *string           `json:"receiverId,omitempty"`
+	GroupID    *string           `json:"groupId,omitempty"`
+	Content    string            `json:"content"`
+	Type       string            `json:"type"`
+	Status     string            `json:"status"`
+	ReplyToID  *string           `json:"replyToId,omitempty"`
+	ReplyTo    *ReplyPreview     `json:"replyTo,omitempty"`
+	EditedAt   *time.Time        `json:"editedAt,omitempty"`
+	IsEdited   bool              `json:"isEdited"`
+	DeletedAt  *time.Time        `json:"deletedAt,omitempty"`
+	IsDeleted  bool              `json:"isDeleted"`
+	Reactions  []ReactionSummary `json:"reactions"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+// MessageSender is the public profile of a message's sender shown with each message
+type MessageSender struct {
+	ID       string  `json:"id"`
+	UniqueID string  `json:"uniqueId"`
+	Name     string  `json:"name"`
+	Avatar   *string `json:"avatar,omitempty"` // Omitted when hidden by the sender's privacy settings
+}
+
+// ReplyPreview is a short summary of the message being replied to
+type ReplyPreview struct {
+	ID         string `json:"id"`
+	SenderID   string `json:"senderId"`
+	SenderName string `json:"senderName"`
+	Type       string `json:"type"`
+	Content    string `json:"content"` // Truncated, empty if deleted
+	IsDeleted  bool   `json:"isDeleted"`
+}
+
+// MessageEdit represents a previous revision of an edited message
+type MessageEdit struct {
+	ID              string    `json:"id" db:"id"`
+	MessageID       string    `json:"messageId" db:"message_id"`
+	EditedBy        string    `json:"editedBy" db:"edited_by"`
+	PreviousContent string    `json:"previousContent" db:"previous_content"`
+	EditedAt        time.Time `json:"editedAt" db:"edited_at"`
+}
+
+// MessageReaction represents a user's emoji reaction to a message
+type MessageReaction struct {
+	MessageID string    `json:"messageId" db:"message_id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	Emoji     string    `json:"emoji" db:"emoji"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// ReactionSummary aggregates the reactions with one emoji on a message
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
 }
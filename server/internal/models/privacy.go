@@ -0,0 +1,58 @@
+package models
+
+// Audience is who may see a part of a user's profile
+type Audience string
+
+const (
+	AudienceEveryone Audience = "everyone"
+	AudienceContacts Audience = "contacts" // Users in the owner's contact list
+	AudienceNobody   Audience = "nobody"
+)
+
+// IsValid reports whether the audience is one users may choose
+func (a Audience) IsValid() bool {
+	switch a {
+	case AudienceEveryone, AudienceContacts, AudienceNobody:
+		return true
+	}
+	return false
+}
+
+// Allows reports whether a viewer may see something shared with the audience.
+// isContact reports whether the viewer is in the owner's contact list.
+func (a Audience) Allows(isContact bool) bool {
+	switch a {
+	case AudienceContacts:
+		return isContact
+	case AudienceNobody:
+		return false
+	}
+	return true // Unset means everyone
+}
+
+// PrivacySettings controls who sees a user's presence, profile and read receipts
+type PrivacySettings struct {
+	LastSeen     Audience `json:"lastSeen" db:"privacy_last_seen"`
+	Online       Audience `json:"online" db:"privacy_online"` // Online dot and status
+	Avatar       Audience `json:"avatar" db:"privacy_avatar"`
+	ReadReceipts Audience `json:"readReceipts" db:"privacy_read_receipts"`
+}
+
+// applyPrivacy hides what the user does not share with the viewer.
+// isContact reports whether the viewer is in the user's contact list.
+func (r *UserResponse) applyPrivacy(settings PrivacySettings, isContact bool) {
+	if !settings.LastSeen.Allows(isContact) {
+		r.LastSeen = nil
+	}
+
+	if !settings.Avatar.Allows(isContact) {
+		r.Avatar = nil
+	}
+
+	if !settings.Online.Allows(isContact) {
+		r.hideOnline = true
+		r.StatusText = nil
+		r.StatusExpiresAt = nil
+		r.SetOnline(false)
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Session represents a login on one device. Tokens carry the session ID and stop
+// working once the session is revoked or expires.
+type Session struct {
+	ID           string     `json:"id" db:"id"`
+	UserID       string     `json:"-" db:"user_id"`
+	IPAddress    *string    `json:"ipAddress,omitempty" db:"ip_address"`
+	UserAgent    *string    `json:"userAgent,omitempty" db:"user_agent"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	LastActiveAt time.Time  `json:"lastActiveAt" db:"last_active_at"`
+	ExpiresAt    time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt    *time.Time `json:"-" db:"revoked_at"`
+
+	Current bool `json:"current"` // The session the request was made with
+}
+
+// SecurityEventType identifies a security alert
+type SecurityEventType string
+
+const (
+	// SecurityEventRefreshTokenReuse is recorded when a refresh token is presented
+	// after it was rotated, which means it was copied; the session is revoked
+	SecurityEventRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+
+	// SecurityEventTwoFactorEnabled and SecurityEventTwoFactorDisabled record 2FA changes
+	SecurityEventTwoFactorEnabled  SecurityEventType = "two_factor_enabled"
+	SecurityEventTwoFactorDisabled SecurityEventType = "two_factor_disabled"
+
+	// SecurityEventRecoveryCodeUsed is recorded when a recovery code replaces the authenticator
+	SecurityEventRecoveryCodeUsed SecurityEventType = "recovery_code_used"
+
+	// SecurityEventPasswordReset is recorded when the password is reset by email; all sessions are revoked
+	SecurityEventPasswordReset SecurityEventType = "password_reset"
+)
+
+// SecurityEvent is a security alert shown to a user
+type SecurityEvent struct {
+	ID        string            `json:"id" db:"id"`
+	Type      SecurityEventType `json:"type" db:"type"`
+	SessionID *string           `json:"sessionId,omitempty" db:"session_id"`
+	IPAddress *string           `json:"ipAddress,omitempty" db:"ip_address"`
+	UserAgent *string           `json:"userAgent,omitempty" db:"user_agent"`
+	CreatedAt time.Time         `json:"createdAt" db:"created_at"`
+}
@@ -16,32 +16,123 @@ type User struct {
 	LastSeen     time.Time `json:"lastSeen" db:"last_seen"`
 	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+
+	PresenceStatus  PresenceStatus `json:"status" db:"presence_status"`
+	StatusText      *string        `json:"statusText,omitempty" db:"status_text"`
+	StatusExpiresAt *time.Time     `json:"statusExpiresAt,omitempty" db:"status_expires_at"`
+
+	Privacy PrivacySettings `json:"-"`
+}
+
+// PresenceStatus is the availability a user shows to others
+type PresenceStatus string
+
+const (
+	PresenceOnline    PresenceStatus = "online"
+	PresenceIdle      PresenceStatus = "idle"
+	PresenceAway      PresenceStatus = "away"
+	PresenceDND       PresenceStatus = "dnd"
+	PresenceInvisible PresenceStatus = "invisible" // Connected, but shown as offline
+
+	// PresenceOffline is shown for users who are disconnected or invisible
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// MaxStatusTextLength is the maximum length of a custom status
+const MaxStatusTextLength = 100
+
+// IsValid reports whether a user may choose the status
+func (s PresenceStatus) IsValid() bool {
+	switch s {
+	case PresenceOnline, PresenceIdle, PresenceAway, PresenceDND, PresenceInvisible:
+		return true
+	}
+	return false
 }
 
 // UserResponse is what we send to clients (without sensitive data)
 type UserResponse struct {
-	ID           string    `json:"id"`
-	UniqueID     string    `json:"uniqueId"`
-	Email        string    `json:"email"`
-	Name         string    `json:"name"`
-	Avatar       *string   `json:"avatar,omitempty"`
-	AuthProvider string    `json:"authProvider"`
-	IsOnline     bool      `json:"isOnline"`
-	LastSeen     time.Time `json:"lastSeen"`
-	CreatedAt    time.Time `json:"createdAt"`
-}
-
-// ToResponse converts User to UserResponse
-func (u *User) ToResponse() UserResponse {
+	ID           string     `json:"id"`
+	UniqueID     string     `json:"uniqueId"`
+	Email        string     `json:"email"`
+	Name         string     `json:"name"`
+	Avatar       *string    `json:"avatar,omitempty"`
+	AuthProvider string     `json:"authProvider"`
+	IsOnline     bool       `json:"isOnline"`
+	LastSeen     *time.Time `json:"lastSeen"` // null when hidden by the user's privacy settings
+	CreatedAt    time.Time  `json:"createdAt"`
+
+	Status          PresenceStatus `json:"status"` // online, idle, away, dnd or offline (invisible only to the user)
+	StatusText      *string        `json:"statusText,omitempty"`
+	StatusExpiresAt *time.Time     `json:"statusExpiresAt,omitempty"`
+
+	chosenStatus PresenceStatus // Status the user chose, kept to recompute Status in SetOnline
+	hideOnline   bool           // Set by applyPrivacy when the viewer may not see the online status
+}
+
+// ToResponse converts User to UserResponse as another user sees it. Invisible users
+// appear offline and whatever u.Privacy does not share with the viewer is hidden.
+// isContact reports whether the viewer is in the user's contact list.
+func (u *User) ToResponse(isContact bool) UserResponse {
+	response := u.toResponse()
+	response.SetOnline(u.IsOnline)
+	response.applyPrivacy(u.Privacy, isContact)
+	return response
+}
+
+// ToOwnResponse converts User to UserResponse for the user themselves, with their real status
+func (u *User) ToOwnResponse() UserResponse {
+	response := u.toResponse()
+	response.IsOnline = u.IsOnline
+	response.Status = response.chosenStatus
+	return response
+}
+
+// toResponse copies the fields shared by every view of a user
+func (u *User) toResponse() UserResponse {
+	status, statusText, expiresAt := u.CurrentPresence()
+	lastSeen := u.LastSeen
+
 	return UserResponse{
-		ID:           u.ID,
-		UniqueID:     u.UniqueID,
-		Email:        u.Email,
-		Name:         u.Name,
-		Avatar:       u.Avatar,
-		AuthProvider: u.AuthProvider,
-		IsOnline:     u.IsOnline,
-		LastSeen:     u.LastSeen,
-		CreatedAt:    u.CreatedAt,
+		ID:              u.ID,
+		UniqueID:        u.UniqueID,
+		Email:           u.Email,
+		Name:            u.Name,
+		Avatar:          u.Avatar,
+		AuthProvider:    u.AuthProvider,
+		LastSeen:        &lastSeen,
+		CreatedAt:       u.CreatedAt,
+		StatusText:      statusText,
+		StatusExpiresAt: expiresAt,
+		chosenStatus:    status,
+	}
+}
+
+// CurrentPresence returns the user's chosen status and custom status text.
+// An expired custom status reads as online without text.
+func (u *User) CurrentPresence() (PresenceStatus, *string, *time.Time) {
+	if u.StatusExpiresAt != nil && !u.StatusExpiresAt.After(time.Now()) {
+		return PresenceOnline, nil, nil
+	}
+
+	status := u.PresenceStatus
+	if !status.IsValid() {
+		status = PresenceOnline
+	}
+
+	return status, u.StatusText, u.StatusExpiresAt
+}
+
+// SetOnline sets whether the user is connected and the status shown for it
+func (r *UserResponse) SetOnline(online bool) {
+	r.IsOnline = online && r.chosenStatus != PresenceInvisible && !r.hideOnline
+
+	if r.IsOnline {
+		r.Status = r.chosenStatus
+		if r.Status == "" {
+			r.Status = PresenceOnline
+		}
+	} else {
+		r.Status = PresenceOffline
 	}
 }
@@ -1,8 +1,11 @@
 package routes
 
 import (
+	"context"
+
 	"ngabarin/server/internal/handlers"
 	"ngabarin/server/internal/middleware"
+	ws "ngabarin/server/internal/websocket"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +16,11 @@ func InitWebSocket() {
 	handlers.InitWebSocket()
 }
 
+// ShutdownWebSocket drains the WebSocket hub before the server stops
+func ShutdownWebSocket(ctx context.Context) error {
+	return handlers.ShutdownWebSocket(ctx)
+}
+
 // SetupRoutes configures all application routes
 func SetupRoutes(app *fiber.App) {
 	// API v1 group
@@ -31,8 +39,21 @@ func SetupRoutes(app *fiber.App) {
 	auth.Post("/register", middleware.StrictRateLimiter(), handlers.Register)
 	auth.Post("/login", middleware.StrictRateLimiter(), handlers.Login)
 	auth.Post("/refresh", middleware.StrictRateLimiter(), handlers.RefreshToken)
+	auth.Post("/verify-email", middleware.StrictRateLimiter(), handlers.VerifyEmail)
+	auth.Post("/resend-verification", middleware.StrictRateLimiter(), handlers.ResendVerification)
+	auth.Post("/forgot-password", middleware.StrictRateLimiter(), handlers.ForgotPassword)
+	auth.Post("/reset-password", middleware.StrictRateLimiter(), handlers.ResetPassword)
 	auth.Post("/logout", middleware.AuthMiddleware, handlers.Logout)
 	auth.Get("/me", middleware.AuthMiddleware, handlers.GetMe)
+	auth.Get("/sessions", middleware.AuthMiddleware, handlers.GetSessions)
+	auth.Delete("/sessions", middleware.AuthMiddleware, handlers.RevokeAllSessions) // ?keepCurrent=true keeps this device
+	auth.Delete("/sessions/:sessionId", middleware.AuthMiddleware, handlers.RevokeSession)
+	auth.Get("/security-events", middleware.AuthMiddleware, handlers.GetSecurityEvents)
+	auth.Get("/2fa", middleware.AuthMiddleware, handlers.GetTwoFactorStatus)
+	auth.Post("/2fa/setup", middleware.AuthMiddleware, handlers.SetupTwoFactor)
+	auth.Post("/2fa/confirm", middleware.AuthMiddleware, middleware.StrictRateLimiter(), handlers.ConfirmTwoFactor)
+	auth.Post("/2fa/verify", middleware.StrictRateLimiter(), handlers.VerifyTwoFactor) // Second login step
+	auth.Post("/2fa/disable", middleware.AuthMiddleware, middleware.StrictRateLimiter(), handlers.DisableTwoFactor)
 	auth.Get("/google", handlers.GoogleOAuthURL)
 	auth.Get("/google/callback", handlers.GoogleOAuthCallback)
 	// Contact routes (protected)
@@ -42,6 +63,22 @@ func SetupRoutes(app *fiber.App) {
 	contacts.Get("/search", handlers.SearchContacts)
 	contacts.Delete("/:contactId", handlers.RemoveContact)
 
+	// Block list routes (protected)
+	blocks := api.Group("/blocks", middleware.AuthMiddleware)
+	blocks.Get("/", handlers.GetBlockedUsers)
+	blocks.Post("/", handlers.BlockUser)
+	blocks.Delete("/:userId", handlers.UnblockUser)
+
+	// Presence routes (protected)
+	presence := api.Group("/presence", middleware.AuthMiddleware)
+	presence.Get("/", handlers.GetPresence)
+	presence.Put("/", handlers.SetPresence)
+
+	// Privacy settings routes (protected)
+	privacy := api.Group("/privacy", middleware.AuthMiddleware)
+	privacy.Get("/", handlers.GetPrivacySettings)
+	privacy.Put("/", handlers.UpdatePrivacySettings)
+
 	// Message routes (protected)
 	messages := api.Group("/messages", middleware.AuthMiddleware)
 	messages.Get("/chats", handlers.GetChats) // Get all chats (contacts + non-contacts with messages)
@@ -49,14 +86,27 @@ func SetupRoutes(app *fiber.App) {
 	messages.Get("/:chatId", handlers.GetMessages)
 	messages.Put("/read", handlers.MarkAsRead)
 	messages.Patch("/:messageId/status", handlers.UpdateMessageStatus)
+	messages.Patch("/:messageId", handlers.EditMessage)
+	messages.Delete("/:messageId", handlers.DeleteMessage)
+	messages.Get("/:messageId/edits", handlers.GetMessageEdits)
+	messages.Get("/:messageId/thread", handlers.GetThread)
+	messages.Post("/:messageId/reactions", handlers.AddReaction)
+	messages.Delete("/:messageId/reactions", handlers.RemoveReaction)
 	messages.Post("/group", handlers.SendGroupMessage)
 	messages.Get("/group/:groupId", handlers.GetGroupMessages)
 
+	// Search routes (protected)
+	search := api.Group("/search", middleware.AuthMiddleware)
+	search.Get("/messages", handlers.SearchMessages)
+
 	// Upload routes (protected)
 	uploads := api.Group("/upload", middleware.AuthMiddleware)
 	uploads.Post("/file", middleware.UploadRateLimiter(), handlers.UploadFile)
 	uploads.Post("/avatar", middleware.UploadRateLimiter(), handlers.UploadAvatar)
 
+	// Public keys for verifying Ngabarin tokens (public)
+	app.Get("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// Serve uploaded files (public)
 	app.Get("/uploads/:type/:filename", handlers.GetFile)
 
@@ -72,7 +122,16 @@ func SetupRoutes(app *fiber.App) {
 	groups.Post("/:groupId/leave", handlers.LeaveGroup)
 
 	// WebSocket route (protected)
-	api.Get("/ws", middleware.AuthMiddleware, handlers.WebSocketUpgrade, websocket.New(handlers.WebSocketHandler))
+	api.Get("/ws", middleware.AuthMiddleware, handlers.WebSocketUpgrade, websocket.New(handlers.WebSocketHandler, websocket.Config{
+		Subprotocols:      ws.Subprotocols,
+		EnableCompression: true, // permessage-deflate, used for JSON frames
+	}))
+
+	// Event fallbacks for clients without WebSockets (protected)
+	events := api.Group("/events", middleware.AuthMiddleware)
+	events.Get("/", handlers.EventStream)      // Server-Sent Events
+	events.Get("/poll", handlers.PollEvents)   // Long polling
+	events.Post("/", handlers.SendClientEvent) // Client events (typing, send_message, ...)
 
 	// WebSocket stats (protected, for debugging)
 	api.Get("/ws/stats", middleware.AuthMiddleware, handlers.GetWebSocketStats)
@@ -1,68 +1,126 @@
 package utils
 
 import (
-	"os"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+const (
+	// AccessTokenTTL is how long an access token is valid
+	AccessTokenTTL = 15 * time.Minute
+
+	// RefreshTokenTTL is how long a refresh token, and a session without activity, is valid
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	// MFATokenTTL is how long a user has to enter their second factor after the password
+	MFATokenTTL = 5 * time.Minute
+)
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID   string `json:"userId"`
-	Email    string `json:"email"`
-	UniqueID string `json:"uniqueId"`
-	Type     string `json:"type"` // "access" or "refresh"
+	UserID    string `json:"userId"`
+	Email     string `json:"email"`
+	UniqueID  string `json:"uniqueId"`
+	Type      string `json:"type"` // "access", "refresh" or "mfa_pending"
+	SessionID string `json:"sid"`  // Login session the token belongs to
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a JWT access token for a user
-func GenerateToken(userID, email, uniqueID string) (string, error) {
-	expirationTime := time.Now().Add(15 * time.Minute) // 15 minutes for access token
+// GenerateToken generates a JWT access token for a user's session
+func GenerateToken(userID, email, uniqueID, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 
 	claims := &Claims{
-		UserID:   userID,
-		Email:    email,
-		UniqueID: uniqueID,
-		Type:     "access",
+		UserID:    userID,
+		Email:     email,
+		UniqueID:  uniqueID,
+		Type:      "access",
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// GenerateRefreshToken generates a JWT refresh token for a user's session. tokenID
+// identifies the token within the session's rotation family.
+func GenerateRefreshToken(userID, email, uniqueID, sessionID, tokenID string) (string, error) {
+	expirationTime := time.Now().Add(RefreshTokenTTL)
+
+	claims := &Claims{
+		UserID:    userID,
+		Email:     email,
+		UniqueID:  uniqueID,
+		Type:      "refresh",
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
 }
 
-// GenerateRefreshToken generates a JWT refresh token for a user
-func GenerateRefreshToken(userID, email, uniqueID string) (string, error) {
-	expirationTime := time.Now().Add(7 * 24 * time.Hour) // 7 days for refresh token
+// GenerateMFAToken generates a short-lived token proving a user entered their
+// password, exchanged for a session once they also enter a second factor
+func GenerateMFAToken(userID, email, uniqueID string) (string, error) {
+	expirationTime := time.Now().Add(MFATokenTTL)
 
 	claims := &Claims{
 		UserID:   userID,
 		Email:    email,
 		UniqueID: uniqueID,
-		Type:     "refresh",
+		Type:     "mfa_pending",
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
 }
 
-// ValidateToken validates and parses a JWT token
+// signToken sets the issuer and audience and signs the claims with the current
+// signing key, naming it in the kid header
+func signToken(claims *Claims) (string, error) {
+	if err := InitJWT(); err != nil {
+		return "", err
+	}
+
+	claims.Issuer = jwtKeys.issuer
+	claims.Audience = jwt.ClaimStrings{jwtKeys.audience}
+
+	if jwtKeys.signing == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(jwtKeys.secret)
+	}
+
+	token := jwt.NewWithClaims(jwtKeys.signing.method, claims)
+	token.Header["kid"] = jwtKeys.signing.id
+	return token.SignedString(jwtKeys.signing.private)
+}
+
+// ValidateToken validates and parses a JWT token. The kid header picks the
+// verification key, and the issuer and audience must match this server's.
 func ValidateToken(tokenString string) (*Claims, error) {
+	if err := InitJWT(); err != nil {
+		return nil, err
+	}
+
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey,
+		jwt.WithIssuer(jwtKeys.issuer),
+		jwt.WithAudience(jwtKeys.audience),
+		jwt.WithExpirationRequired(),
+	)
 
 	if err != nil {
 		return nil, err
@@ -74,3 +132,31 @@ func ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// verificationKey returns the key a token was signed with, refusing any algorithm
+// other than the one that key is for. HS256 tokens, which have no kid, are only
+// accepted without a signing key or during the JWT_ACCEPT_LEGACY_HS256 window.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		if len(jwtKeys.secret) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("token has no kid")
+		}
+		if jwtKeys.signing != nil && !time.Now().Before(jwtKeys.legacy) {
+			return nil, fmt.Errorf("HS256 tokens are no longer accepted")
+		}
+		return jwtKeys.secret, nil
+	}
+
+	key, ok := jwtKeys.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	return key.public, nil
+}
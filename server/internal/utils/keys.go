@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultJWTIssuer is the iss claim of tokens when JWT_ISSUER is not set
+	DefaultJWTIssuer = "ngabarin"
+
+	// DefaultJWTAudience is the aud claim of tokens when JWT_AUDIENCE is not set
+	DefaultJWTAudience = "ngabarin"
+
+	// minRSAKeyBits is the smallest RSA key accepted for signing or verification
+	minRSAKeyBits = 2048
+)
+
+// jwtKey is a key tokens are signed or verified with
+type jwtKey struct {
+	id      string // kid header (the RFC 7638 thumbprint of the public key)
+	method  jwt.SigningMethod
+	private interface{} // nil for verification-only keys
+	public  interface{}
+}
+
+// jwtKeySet holds the signing key and every key tokens may be verified with
+type jwtKeySet struct {
+	signing  *jwtKey
+	verify   map[string]*jwtKey // Asymmetric keys by kid
+	secret   []byte             // HS256 secret, for tokens without a kid
+	legacy   time.Time          // Until when HS256 tokens are accepted alongside a signing key
+	issuer   string
+	audience string
+}
+
+var (
+	jwtKeys     *jwtKeySet
+	jwtKeysErr  error
+	jwtKeysOnce sync.Once
+)
+
+// InitJWT loads the signing and verification keys from the environment. It runs
+// on first use; call it at startup to fail fast on a bad key file.
+//
+// JWT_PRIVATE_KEY_FILE is a PEM RSA or Ed25519 private key tokens are signed with
+// (RS256 or EdDSA). JWT_PUBLIC_KEY_FILES lists PEM public keys, comma-separated,
+// that are still accepted, e.g. the previous key during a rotation. Without a
+// private key, tokens are signed with HS256 and JWT_SECRET. Once a private key is
+// set, HS256 tokens are refused unless JWT_ACCEPT_LEGACY_HS256 names an RFC 3339
+// time, at most RefreshTokenTTL away, until which they are still accepted while
+// sessions move to asymmetric keys.
+func InitJWT() error {
+	jwtKeysOnce.Do(func() {
+		jwtKeys, jwtKeysErr = loadJWTKeys()
+	})
+	return jwtKeysErr
+}
+
+// loadJWTKeys reads the keys and claims configuration from the environment
+func loadJWTKeys() (*jwtKeySet, error) {
+	keys := &jwtKeySet{
+		verify:   make(map[string]*jwtKey),
+		secret:   []byte(os.Getenv("JWT_SECRET")),
+		issuer:   os.Getenv("JWT_ISSUER"),
+		audience: os.Getenv("JWT_AUDIENCE"),
+	}
+
+	if keys.issuer == "" {
+		keys.issuer = DefaultJWTIssuer
+	}
+	if keys.audience == "" {
+		keys.audience = DefaultJWTAudience
+	}
+
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		key, err := readJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		if key.private == nil {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE: %s holds no private key", path)
+		}
+
+		keys.signing = key
+		keys.verify[key.id] = key
+	}
+
+	for _, path := range strings.Split(os.Getenv("JWT_PUBLIC_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := readJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_PUBLIC_KEY_FILES: %w", err)
+		}
+
+		if _, ok := keys.verify[key.id]; !ok {
+			key.private = nil
+			keys.verify[key.id] = key
+		}
+	}
+
+	if keys.signing == nil && len(keys.secret) == 0 {
+		return nil, errors.New("set JWT_PRIVATE_KEY_FILE or JWT_SECRET")
+	}
+
+	if value := os.Getenv("JWT_ACCEPT_LEGACY_HS256"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_ACCEPT_LEGACY_HS256: %w", err)
+		}
+		if time.Until(until) > RefreshTokenTTL {
+			return nil, fmt.Errorf("JWT_ACCEPT_LEGACY_HS256: %s is more than %s away", value, RefreshTokenTTL)
+		}
+		keys.legacy = until
+	}
+
+	return keys, nil
+}
+
+// readJWTKey parses a PEM file holding a private or public RSA or Ed25519 key
+func readJWTKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &jwtKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", path)
+	}
+
+	if rsaKey, ok := key.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("%s: RSA keys must be at least %d bits", path, minRSAKeyBits)
+	}
+
+	key.id = thumbprint(publicJWK(key))
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns every key tokens are verified with, so other services can verify them.
+// The HS256 secret is never published.
+func PublicJWKS() (JWKSet, error) {
+	if err := InitJWT(); err != nil {
+		return JWKSet{}, err
+	}
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range jwtKeys.verify {
+		jwk := publicJWK(key)
+		jwk.KeyID = key.id
+		jwk.Use = "sig"
+		jwk.Algorithm = key.method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+
+	return set, nil
+}
+
+// publicJWK returns the public part of a key with only the members its thumbprint covers
+func publicJWK(key *jwtKey) JWK {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       encode(public.N.Bytes()),
+			E:       encode(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       encode(public),
+		}
+	}
+
+	return JWK{}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public JWK, used as its kid
+func thumbprint(jwk JWK) string {
+	// The required members in lexicographic order (encoding/json sorts map keys)
+	members := map[string]string{"kty": jwk.KeyType}
+	switch jwk.KeyType {
+	case "RSA":
+		members["n"], members["e"] = jwk.N, jwk.E
+	case "OKP":
+		members["crv"], members["x"] = jwk.Curve, jwk.X
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ngabarin/server/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTypingThrottle is the minimum interval between typing_start events a user sends to one chat
+const DefaultTypingThrottle = 2 * time.Second
+
+// Authorizer decides whether a user may send events or messages to a chat or group
+type Authorizer interface {
+	// CanAccessChat checks that a user may send events to a DM peer
+	CanAccessChat(ctx context.Context, userID, peerID string) (bool, error)
+
+	// CanAccessGroup checks that a user may send events to a group
+	CanAccessGroup(ctx context.Context, userID, groupID string) (bool, error)
+
+	// CanFollowPresence reports which of the given users a user may subscribe to the presence of
+	CanFollowPresence(ctx context.Context, userID string, peerIDs []string) (map[string]bool, error)
+
+	// IsBlocked checks whether either user blocked the other
+	IsBlocked(ctx context.Context, userID, peerID string) (bool, error)
+}
+
+// DatabaseAuthorizer checks relationships, group membership and blocks in Postgres
+type DatabaseAuthorizer struct{}
+
+// CanAccessChat allows users who are contacts or have DM history, unless either blocked the other
+func (DatabaseAuthorizer) CanAccessChat(ctx context.Context, userID, peerID string) (bool, error) {
+	if userID == peerID {
+		return false, nil
+	}
+
+	if _, err := uuid.Parse(peerID); err != nil {
+		return false, nil
+	}
+
+	var allowed bool
+	err := database.Pool.QueryRow(ctx, `
+		SELECT NOT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		) AND (
+			EXISTS(
+				SELECT 1 FROM contacts
+				WHERE (user_id = $1 AND contact_id = $2) OR (user_id = $2 AND contact_id = $1)
+			)
+			OR EXISTS(
+				SELECT 1 FROM messages
+				WHERE group_id IS NULL
+				AND ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
+			)
+		)
+	`, userID, peerID).Scan(&allowed)
+
+	return allowed, err
+}
+
+// IsBlocked checks whether either user blocked the other. Message sends over REST
+// and the WebSocket are refused with it.
+func (DatabaseAuthorizer) IsBlocked(ctx context.Context, userID, peerID string) (bool, error) {
+	if _, err := uuid.Parse(peerID); err != nil {
+		return false, nil
+	}
+
+	var blocked bool
+	err := database.Pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`, userID, peerID).Scan(&blocked)
+
+	return blocked, err
+}
+
+// CanFollowPresence allows users who are contacts, have DM history or share a group,
+// unless either blocked the other
+func (DatabaseAuthorizer) CanFollowPresence(ctx context.Context, userID string, peerIDs []string) (map[string]bool, error) {
+	allowed := make(map[string]bool, len(peerIDs))
+
+	validIDs := make([]string, 0, len(peerIDs))
+	for _, peerID := range peerIDs {
+		if _, err := uuid.Parse(peerID); err == nil && peerID != userID {
+			validIDs = append(validIDs, peerID)
+		}
+	}
+
+	if len(validIDs) == 0 {
+		return allowed, nil
+	}
+
+	rows, err := database.Pool.Query(ctx, `
+		SELECT u.id FROM users u
+		WHERE u.id = ANY($2::uuid[])
+		AND NOT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = u.id) OR (blocker_id = u.id AND blocked_id = $1)
+		) AND (
+			EXISTS(
+				SELECT 1 FROM contacts
+				WHERE (user_id = $1 AND contact_id = u.id) OR (user_id = u.id AND contact_id = $1)
+			)
+			OR EXISTS(
+				SELECT 1 FROM messages
+				WHERE group_id IS NULL
+				AND ((sender_id = $1 AND receiver_id = u.id) OR (sender_id = u.id AND receiver_id = $1))
+			)
+			OR EXISTS(
+				SELECT 1 FROM group_members mine
+				JOIN group_members theirs ON theirs.group_id = mine.group_id
+				WHERE mine.user_id = $1 AND theirs.user_id = u.id
+			)
+		)
+	`, userID, validIDs)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var peerID string
+		if err := rows.Scan(&peerID); err != nil {
+			return nil, err
+		}
+		allowed[peerID] = true
+	}
+
+	return allowed, rows.Err()
+}
+
+// CanAccessGroup allows current group members
+func (DatabaseAuthorizer) CanAccessGroup(ctx context.Context, userID, groupID string) (bool, error) {
+	if _, err := uuid.Parse(groupID); err != nil {
+		return false, nil
+	}
+
+	var isMember bool
+	err := database.Pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = $1 AND user_id = $2)
+	`, groupID, userID).Scan(&isMember)
+
+	return isMember, err
+}
+
+// typingThrottle limits how often a user's typing events reach a chat
+type typingThrottle struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// newTypingThrottle creates an empty throttle
+func newTypingThrottle() *typingThrottle {
+	return &typingThrottle{
+		last: make(map[string]time.Time),
+	}
+}
+
+// allow reports whether an event with the given key may be sent now
+func (t *typingThrottle) allow(key string, interval time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+
+	t.last[key] = now
+	return true
+}
+
+// prune forgets keys that are no longer throttled
+func (t *typingThrottle) prune(interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, last := range t.last {
+		if now.Sub(last) >= interval {
+			delete(t.last, key)
+		}
+	}
+}
+
+// authorizeTarget checks that a user may send events to a DM peer or group
+func (h *Hub) authorizeTarget(userID, peerID, groupID string) *ErrorPayload {
+	var allowed bool
+	var err error
+
+	switch {
+	case groupID != "":
+		allowed, err = h.Authorizer.CanAccessGroup(context.Background(), userID, groupID)
+	case peerID != "":
+		allowed, err = h.Authorizer.CanAccessChat(context.Background(), userID, peerID)
+	default:
+		return &ErrorPayload{Code: "invalid_request", Message: "chatId or groupId is required"}
+	}
+
+	if err != nil {
+		return &ErrorPayload{Code: "internal_error", Message: "Failed to authorize event"}
+	}
+
+	if !allowed {
+		return &ErrorPayload{Code: "forbidden", Message: "You cannot send events to this chat"}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Broker fans hub events out to every server replica. Each replica publishes the
+// events it produces and delivers the ones it receives to its locally connected clients.
+type Broker interface {
+	// Publish sends an event to all replicas
+	Publish(ctx context.Context, envelope Envelope) error
+
+	// Subscribe calls handle for every published event until ctx is cancelled
+	Subscribe(ctx context.Context, handle func(Envelope)) error
+}
+
+// UserWatcher is implemented by brokers that only receive events for users
+// connected to this replica. The hub watches a user on their first local
+// connection and unwatches them after the last one closes.
+type UserWatcher interface {
+	WatchUser(userID string) error
+	UnwatchUser(userID string) error
+}
+
+// PresenceWatcher is implemented by brokers that only receive presence changes
+// of users followed by a connection on this replica. The hub watches a user when
+// their first local subscriber appears and unwatches them after the last one goes.
+type PresenceWatcher interface {
+	WatchPresence(userID string) error
+	UnwatchPresence(userID string) error
+}
+
+// Envelope is an event as it travels between replicas
+type Envelope struct {
+	Origin   string           `json:"origin"`             // NodeID of the publishing replica
+	UserIDs  []string         `json:"userIds"`            // Recipients
+	Message  json.RawMessage  `json:"message"`            // Encoded WSMessage without a sequence
+	Seqs     map[string]int64 `json:"seqs,omitempty"`     // Event log sequence per recipient
+	Presence string           `json:"presence,omitempty"` // Set for presence changes, delivered to the user's subscribers
+	Sessions []string         `json:"sessions,omitempty"` // Set to close the recipients' connections from these revoked sessions
+}
+
+// envelopeMessage decodes the message of an envelope while keeping the payload as is
+type envelopeMessage struct {
+	Type      EventType       `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// publish sends an event produced on this replica to the other replicas
+func (h *Hub) publish(userIDs []string, message WSMessage, seqs map[string]int64) {
+	if h.Broker == nil {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	envelope := Envelope{
+		Origin:  h.NodeID,
+		UserIDs: userIDs,
+		Message: data,
+		Seqs:    seqs,
+	}
+
+	if err := h.Broker.Publish(context.Background(), envelope); err != nil {
+		log.Printf("Failed to publish event %s: %v", message.Type, err)
+	}
+}
+
+// receive delivers an event published by another replica to local clients
+func (h *Hub) receive(envelope Envelope) {
+	// Events from this replica were already delivered locally
+	if envelope.Origin == h.NodeID {
+		return
+	}
+
+	if len(envelope.Sessions) > 0 {
+		for _, userID := range envelope.UserIDs {
+			h.closeSessions(userID, envelope.Sessions)
+		}
+		return
+	}
+
+	var decoded envelopeMessage
+	if err := json.Unmarshal(envelope.Message, &decoded); err != nil {
+		log.Printf("Failed to decode published event: %v", err)
+		return
+	}
+
+	message := WSMessage{
+		Type:      decoded.Type,
+		Payload:   decoded.Payload,
+		Timestamp: decoded.Timestamp,
+	}
+
+	if envelope.Presence != "" {
+		h.notifySubscribers(envelope.Presence, message)
+		return
+	}
+
+	if envelope.Seqs == nil {
+		h.deliverLocal(envelope.UserIDs, message, nil)
+		return
+	}
+
+	// Keep logged events ordered against client registration and replay
+	unlock := h.eventLocks.Lock(envelope.UserIDs...)
+	defer unlock()
+
+	h.deliverLocal(envelope.UserIDs, message, envelope.Seqs)
+}
+
+// subscribe delivers events from other replicas for the lifetime of the hub
+func (h *Hub) subscribe() {
+	if err := h.Broker.Subscribe(h.ctx, h.receive); err != nil && h.ctx.Err() == nil {
+		log.Printf("Event broker subscription ended: %v", err)
+	}
+}
+
+// MemoryBroker is an in-process Broker. It connects hubs running in the same
+// process, which is all a single-node deployment (or a test) needs.
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Envelope)
+	nextID      int
+}
+
+// NewMemoryBroker creates an in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: make(map[int]func(Envelope)),
+	}
+}
+
+// Publish hands the event to every subscriber
+func (b *MemoryBroker) Publish(ctx context.Context, envelope Envelope) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handle := range b.subscribers {
+		handle(envelope)
+	}
+
+	return nil
+}
+
+// Subscribe registers handle until ctx is cancelled
+func (b *MemoryBroker) Subscribe(ctx context.Context, handle func(Envelope)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handle
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.subscribers, id)
+	b.mu.Unlock()
+
+	return ctx.Err()
+}
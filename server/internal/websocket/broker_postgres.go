@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultPostgresChannel is the NOTIFY channel shared by all replicas
+	DefaultPostgresChannel = "ngabarin_hub"
+
+	// maxNotifyPayload keeps notifications under Postgres' 8000 byte payload limit
+	maxNotifyPayload = 7900
+
+	// hubNotificationTTL is how long oversized events are kept for replicas to fetch
+	hubNotificationTTL = 5 * time.Minute
+
+	// postgresReconnectDelay is how long to wait before listening again after a lost connection
+	postgresReconnectDelay = 2 * time.Second
+)
+
+// PostgresBroker fans events out with LISTEN/NOTIFY. Events too large for a
+// notification are stored in hub_notifications and sent by reference.
+type PostgresBroker struct {
+	pool    *pgxpool.Pool
+	channel string
+}
+
+// postgresNotification is a notification payload: either an envelope or a reference to a stored one
+type postgresNotification struct {
+	Ref string `json:"ref,omitempty"`
+	Envelope
+}
+
+// NewPostgresBroker creates a broker on the given pool
+func NewPostgresBroker(pool *pgxpool.Pool) *PostgresBroker {
+	return &PostgresBroker{
+		pool:    pool,
+		channel: DefaultPostgresChannel,
+	}
+}
+
+// Publish sends the event with pg_notify
+func (b *PostgresBroker) Publish(ctx context.Context, envelope Envelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	// Store oversized events and notify their ID instead
+	if len(data) > maxNotifyPayload {
+		var id string
+		err := b.pool.QueryRow(ctx, `
+			INSERT INTO hub_notifications (payload) VALUES ($1) RETURNING id
+		`, data).Scan(&id)
+
+		if err != nil {
+			return err
+		}
+
+		data, err = json.Marshal(postgresNotification{Ref: id})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(data))
+	return err
+}
+
+// Subscribe listens on a dedicated connection, reconnecting until ctx is cancelled.
+// Events published while the connection is down are not delivered live; clients
+// catch up from the event log when they reconnect.
+func (b *PostgresBroker) Subscribe(ctx context.Context, handle func(Envelope)) error {
+	go b.pruneNotifications(ctx)
+
+	for {
+		err := b.listen(ctx, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Printf("Postgres event broker disconnected: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(postgresReconnectDelay):
+		}
+	}
+}
+
+// listen handles notifications until the connection fails
+func (b *PostgresBroker) listen(ctx context.Context, handle func(Envelope)) error {
+	poolConn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The listening connection never goes back to the pool
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var decoded postgresNotification
+		if err := json.Unmarshal([]byte(notification.Payload), &decoded); err != nil {
+			log.Printf("Failed to decode notification: %v", err)
+			continue
+		}
+
+		if decoded.Ref != "" {
+			envelope, err := b.loadNotification(ctx, decoded.Ref)
+			if err != nil {
+				log.Printf("Failed to load notification %s: %v", decoded.Ref, err)
+				continue
+			}
+			decoded.Envelope = envelope
+		}
+
+		handle(decoded.Envelope)
+	}
+}
+
+// loadNotification fetches an oversized event stored by Publish
+func (b *PostgresBroker) loadNotification(ctx context.Context, id string) (Envelope, error) {
+	var data []byte
+	err := b.pool.QueryRow(ctx, `
+		SELECT payload FROM hub_notifications WHERE id = $1
+	`, id).Scan(&data)
+
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	var envelope Envelope
+	err = json.Unmarshal(data, &envelope)
+	return envelope, err
+}
+
+// pruneNotifications removes stored events every replica has had time to fetch
+func (b *PostgresBroker) pruneNotifications(ctx context.Context) {
+	ticker := time.NewTicker(hubNotificationTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := b.pool.Exec(ctx, `
+				DELETE FROM hub_notifications WHERE created_at < $1
+			`, time.Now().Add(-hubNotificationTTL))
+
+			if err != nil {
+				log.Printf("Failed to prune hub notifications: %v", err)
+			}
+		}
+	}
+}
@@ -3,28 +3,60 @@ package websocket
 import (
 	"encoding/json"
 	"log"
+	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 )
 
-// Client represents a WebSocket client connection
+// Client represents a connected client. WebSocket clients have a Conn; clients on
+// the SSE and long-poll transports read their frames with WaitFrames instead.
 type Client struct {
-	ID       string // User ID
-	UniqueID string // User's unique ID (#WORD-123)
-	Conn     *websocket.Conn
-	Hub      *Hub
-	Send     chan []byte
+	ID        string   // User ID
+	UniqueID  string   // User's unique ID (#WORD-123)
+	DeviceID  string   // Identifies this connection among the user's devices
+	SessionID string   // Login session the connection was opened with
+	Name      string   // User's display name, shown in typing indicators
+	Encoding  Encoding // Wire format of frames (JSON text or MessagePack binary)
+	Conn      *websocket.Conn
+	Hub       *Hub
+
+	// Transport the client is connected with
+	Transport Transport
+
+	// Bounded outbound queue drained by WritePump
+	outbox *outbox
+
+	// Since is the last event sequence the client has seen (-1 when it is not resuming)
+	Since int64
+
+	pending     [][]byte      // Missed events written before anything from the outbox
+	replayedSeq int64         // Live events up to this sequence were already replayed
+	ready       chan struct{} // Closed by the hub once pending is set
+	done        chan struct{} // Closed when WritePump returns (nil without a WebSocket)
+
+	// Unix nanoseconds since the user stopped interacting with this client (0 while active)
+	inactiveSince atomic.Int64
+
+	// replies collects reply frames instead of queuing them (for events sent over REST)
+	replies *[]WSMessage
 }
 
 // NewClient creates a new WebSocket client
-func NewClient(userID, uniqueID string, conn *websocket.Conn, hub *Hub) *Client {
+func NewClient(userID, uniqueID, deviceID string, conn *websocket.Conn, hub *Hub) *Client {
 	return &Client{
-		ID:       userID,
-		UniqueID: uniqueID,
-		Conn:     conn,
-		Hub:      hub,
-		Send:     make(chan []byte, 256),
+		ID:        userID,
+		UniqueID:  uniqueID,
+		DeviceID:  deviceID,
+		Conn:      conn,
+		Hub:       hub,
+		Transport: TransportWebSocket,
+		outbox:    newOutbox(hub.SendQueueSize, hub.DropPolicy, &hub.Metrics),
+		Encoding:  EncodingJSON,
+		Since:     -1,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
 	}
 }
 
@@ -42,7 +74,7 @@ func (c *Client) ReadPump() {
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		messageType, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -51,8 +83,8 @@ func (c *Client) ReadPump() {
 		}
 
 		// Parse incoming message
-		var incoming IncomingMessage
-		if err := json.Unmarshal(message, &incoming); err != nil {
+		incoming, err := decodeIncoming(messageType, message)
+		if err != nil {
 			log.Printf("Failed to parse message: %v", err)
 			continue
 		}
@@ -68,19 +100,36 @@ func (c *Client) WritePump() {
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
+		close(c.done)
 	}()
 
+	// Replay missed events before live ones
+	<-c.ready
+	for _, message := range c.pending {
+		c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := c.Conn.WriteMessage(c.Encoding.frameType(), message); err != nil {
+			log.Printf("Write error: %v", err)
+			return
+		}
+	}
+	c.pending = nil
+
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.outbox.notify:
+			frames, closed, code, reason := c.outbox.drain()
+
+			for _, frame := range frames {
+				c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.Conn.WriteMessage(c.Encoding.frameType(), frame.data); err != nil {
+					log.Printf("Write error: %v", err)
+					return
+				}
 			}
 
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("Write error: %v", err)
+			if closed {
+				c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
 				return
 			}
 
@@ -96,28 +145,51 @@ func (c *Client) WritePump() {
 // handleIncomingMessage processes different types of incoming messages
 func (c *Client) handleIncomingMessage(msg IncomingMessage) {
 	switch msg.Type {
-	case EventTypingStart:
-		c.handleTypingStart(msg.Payload)
-	case EventTypingStop:
-		c.handleTypingStop(msg.Payload)
+	case EventTypingStart, EventTypingStop:
+		c.Hub.reportActivity(c.ID, c.DeviceID, 0)
+		c.handleTyping(msg.Type, msg.Payload)
+	case EventSendMessage, EventSendGroupMessage:
+		c.Hub.reportActivity(c.ID, c.DeviceID, 0)
+		c.handleSendMessage(msg.Type, msg.Payload)
+	case EventSetPresence:
+		c.handleSetPresence(msg.Payload)
+	case EventActivity:
+		c.handleActivity(msg.Payload)
+	case EventSubscribePresence:
+		c.handleSubscribePresence(msg.Payload)
+	case EventUnsubscribePresence:
+		c.handleUnsubscribePresence(msg.Payload)
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Unknown event type"})
 	}
 }
 
-// handleTypingStart broadcasts typing start event
-func (c *Client) handleTypingStart(payload map[string]interface{}) {
+// handleTyping broadcasts a typing start/stop event to a chat the user belongs to
+func (c *Client) handleTyping(eventType EventType, payload map[string]interface{}) {
 	chatID, _ := payload["chatId"].(string)
 	groupID, _ := payload["groupId"].(string)
 
+	if errPayload := c.Hub.authorizeTarget(c.ID, chatID, groupID); errPayload != nil {
+		c.sendError(errPayload)
+		return
+	}
+
+	// Drop typing starts sent faster than the throttle allows. Stops always go
+	// through, or peers could be left showing the user as typing.
+	if eventType == EventTypingStart && !c.Hub.typing.allow(c.ID+":"+chatID+groupID, c.Hub.TypingThrottle) {
+		return
+	}
+
 	typingPayload := TypingPayload{
-		UserID:  c.ID,
-		ChatID:  chatID,
-		GroupID: groupID,
+		UserID:   c.ID,
+		ChatID:   chatID,
+		GroupID:  groupID,
+		UserName: c.Name,
 	}
 
 	message := WSMessage{
-		Type:      EventTypingStart,
+		Type:      eventType,
 		Payload:   typingPayload,
 		Timestamp: time.Now(),
 	}
@@ -125,50 +197,166 @@ func (c *Client) handleTypingStart(payload map[string]interface{}) {
 	// Broadcast to relevant users
 	if groupID != "" {
 		c.Hub.BroadcastToGroup(groupID, message, c.ID)
-	} else if chatID != "" {
-		// Extract receiver ID from chatId and send to that user
+	} else {
+		// For DMs, chatId is the other user's ID
 		c.Hub.BroadcastToUsers([]string{chatID}, message)
 	}
 }
 
-// handleTypingStop broadcasts typing stop event
-func (c *Client) handleTypingStop(payload map[string]interface{}) {
-	chatID, _ := payload["chatId"].(string)
-	groupID, _ := payload["groupId"].(string)
+// handleSendMessage stores a message sent over the socket and replies with an ack or error frame
+func (c *Client) handleSendMessage(eventType EventType, payload map[string]interface{}) {
+	var frame SendMessageFrame
+	if err := decodePayload(payload, &frame); err != nil {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Invalid message payload"})
+		return
+	}
 
-	typingPayload := TypingPayload{
-		UserID:  c.ID,
-		ChatID:  chatID,
-		GroupID: groupID,
+	if frame.ClientMessageID == "" {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "clientMessageId is required"})
+		return
 	}
 
-	message := WSMessage{
-		Type:      EventTypingStop,
-		Payload:   typingPayload,
+	if c.Hub.Messages == nil {
+		c.sendError(&ErrorPayload{Code: "unavailable", Message: "Sending over WebSocket is not available", ClientMessageID: frame.ClientMessageID})
+		return
+	}
+
+	// The message service checks group membership and blocks, as it does for REST sends
+	var ack *AckPayload
+	var err error
+	if eventType == EventSendGroupMessage {
+		ack, err = c.Hub.Messages.SendGroupMessage(c.ID, frame)
+	} else {
+		ack, err = c.Hub.Messages.SendDirectMessage(c.ID, frame)
+	}
+
+	if err != nil {
+		errPayload, ok := err.(*ErrorPayload)
+		if !ok {
+			log.Printf("Failed to send message from %s: %v", c.ID, err)
+			errPayload = &ErrorPayload{Code: "internal_error", Message: "Failed to send message"}
+		}
+		errPayload.ClientMessageID = frame.ClientMessageID
+		c.sendError(errPayload)
+		return
+	}
+
+	ack.ClientMessageID = frame.ClientMessageID
+	c.reply(WSMessage{
+		Type:      EventAck,
+		Payload:   ack,
 		Timestamp: time.Now(),
+	})
+}
+
+// handleSetPresence changes the user's status; their devices receive presence_updated
+func (c *Client) handleSetPresence(payload map[string]interface{}) {
+	var frame SetPresenceFrame
+	if err := decodePayload(payload, &frame); err != nil {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Invalid presence payload"})
+		return
 	}
 
-	// Broadcast to relevant users
-	if groupID != "" {
-		c.Hub.BroadcastToGroup(groupID, message, c.ID)
-	} else if chatID != "" {
-		c.Hub.BroadcastToUsers([]string{chatID}, message)
+	if _, errPayload := c.Hub.SetPresence(c.ID, frame); errPayload != nil {
+		c.sendError(errPayload)
 	}
 }
 
+// handleActivity records whether the user is interacting with this client
+func (c *Client) handleActivity(payload map[string]interface{}) {
+	var frame ActivityFrame
+	if err := decodePayload(payload, &frame); err != nil || frame.InactiveFor < 0 {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Invalid activity payload"})
+		return
+	}
+
+	c.Hub.reportActivity(c.ID, c.DeviceID, time.Duration(frame.InactiveFor)*time.Second)
+}
+
+// sendError sends an error frame to the client
+func (c *Client) sendError(payload *ErrorPayload) {
+	c.reply(WSMessage{
+		Type:      EventError,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	})
+}
+
+// reply answers an inbound event
+func (c *Client) reply(msg WSMessage) {
+	if c.replies != nil {
+		*c.replies = append(*c.replies, msg)
+		return
+	}
+
+	c.SendMessage(msg)
+}
+
+// decodePayload converts a generic frame payload into a typed struct
+func decodePayload(payload map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
 // SendMessage sends a message to the client
 func (c *Client) SendMessage(msg WSMessage) error {
-	data, err := json.Marshal(msg)
+	data, err := newEncodedMessage(msg).encode(c.Encoding)
 	if err != nil {
 		return err
 	}
 
-	select {
-	case c.Send <- data:
-	default:
-		close(c.Send)
-		c.Hub.Unregister <- c
+	c.enqueue(data, isDroppable(msg.Type))
+	return nil
+}
+
+// enqueueMessage encodes a message in the client's encoding and queues it
+func (c *Client) enqueueMessage(message *encodedMessage, droppable bool) {
+	data, err := message.encode(c.Encoding)
+	if err != nil {
+		log.Printf("Failed to encode message: %v", err)
+		return
 	}
 
-	return nil
+	c.enqueue(data, droppable)
+}
+
+// enqueue queues an encoded frame for the write pump
+func (c *Client) enqueue(data []byte, droppable bool) {
+	if !c.outbox.push(data, droppable) {
+		log.Printf("Dropped frame for client: %s (device %s)", c.ID, c.DeviceID)
+	}
+}
+
+// restart tells the client the server is going away and closes the connection
+func (c *Client) restart() {
+	c.SendMessage(WSMessage{
+		Type: EventServerRestarting,
+		Payload: ServerRestartingPayload{
+			// Spread reconnects out so clients do not all return at once
+			RetryAfter: (restartRetryMin + time.Duration(rand.Int63n(int64(restartRetryJitter)))).Milliseconds(),
+		},
+		Timestamp: time.Now(),
+	})
+
+	c.Close(websocket.CloseServiceRestart, "server restarting")
+}
+
+// revoke tells the client its login session ended and closes the connection
+func (c *Client) revoke() {
+	c.SendMessage(WSMessage{
+		Type:      EventSessionRevoked,
+		Payload:   SessionRevokedPayload{SessionID: c.SessionID},
+		Timestamp: time.Now(),
+	})
+
+	c.Close(websocket.ClosePolicyViolation, "session revoked")
+}
+
+// Close stops sending to the client and closes the connection with the given code
+// once the frames already queued are written
+func (c *Client) Close(code int, reason string) {
+	c.outbox.close(code, reason)
 }
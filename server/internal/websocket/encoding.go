@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding is the wire format of a client's frames
+type Encoding string
+
+const (
+	// EncodingJSON sends JSON text frames (the default)
+	EncodingJSON Encoding = "json"
+
+	// EncodingMsgpack sends MessagePack binary frames with the same shape as the JSON ones
+	EncodingMsgpack Encoding = "msgpack"
+)
+
+const (
+	// SubprotocolJSON selects JSON frames during the WebSocket handshake
+	SubprotocolJSON = "ngabarin.json"
+
+	// SubprotocolMsgpack selects MessagePack frames during the WebSocket handshake
+	SubprotocolMsgpack = "ngabarin.msgpack"
+)
+
+// Subprotocols lists the subprotocols the server accepts, in order of preference
+var Subprotocols = []string{SubprotocolJSON, SubprotocolMsgpack}
+
+// NegotiateEncoding picks the encoding from the negotiated subprotocol,
+// falling back to the ?encoding= query parameter
+func NegotiateEncoding(subprotocol, query string) Encoding {
+	switch subprotocol {
+	case SubprotocolMsgpack:
+		return EncodingMsgpack
+	case SubprotocolJSON:
+		return EncodingJSON
+	}
+
+	if strings.EqualFold(query, string(EncodingMsgpack)) {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+// frameType returns the WebSocket message type used for the encoding
+func (e Encoding) frameType() int {
+	if e == EncodingMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// encodedMessage encodes a message at most once per encoding
+type encodedMessage struct {
+	message WSMessage
+	json    []byte
+	msgpack []byte
+}
+
+// newEncodedMessage wraps a message for lazy encoding
+func newEncodedMessage(message WSMessage) *encodedMessage {
+	return &encodedMessage{message: message}
+}
+
+// encode returns the message in the given encoding
+func (m *encodedMessage) encode(encoding Encoding) ([]byte, error) {
+	if m.json == nil {
+		data, err := json.Marshal(m.message)
+		if err != nil {
+			return nil, err
+		}
+		m.json = data
+	}
+
+	if encoding != EncodingMsgpack {
+		return m.json, nil
+	}
+
+	if m.msgpack == nil {
+		data, err := jsonToMsgpack(m.json)
+		if err != nil {
+			return nil, err
+		}
+		m.msgpack = data
+	}
+
+	return m.msgpack, nil
+}
+
+// jsonToMsgpack re-encodes a JSON document as MessagePack, so both encodings
+// share field names, omitted fields and timestamp format
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.UseCompactInts(true)
+
+	if err := encoder.Encode(normalizeNumbers(value)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// normalizeNumbers turns json.Number values into integers or floats
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	}
+	return value
+}
+
+// decodeIncoming parses a frame from a client in either encoding
+func decodeIncoming(messageType int, data []byte) (IncomingMessage, error) {
+	var incoming IncomingMessage
+
+	if messageType == websocket.BinaryMessage {
+		var value interface{}
+		if err := msgpack.Unmarshal(data, &value); err != nil {
+			return incoming, err
+		}
+
+		// Go through JSON so payloads decode exactly like text frames
+		converted, err := json.Marshal(value)
+		if err != nil {
+			return incoming, err
+		}
+		data = converted
+	}
+
+	err := json.Unmarshal(data, &incoming)
+	return incoming, err
+}
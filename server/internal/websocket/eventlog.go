@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"ngabarin/server/internal/database"
+)
+
+const (
+	// DefaultEventRetention is how long logged events stay available for replay
+	DefaultEventRetention = 24 * time.Hour
+
+	// MaxReplayEvents is the most events replayed on reconnect before asking for a resync
+	MaxReplayEvents = 1000
+
+	// eventLogPruneInterval is how often expired events are removed from the log
+	eventLogPruneInterval = 10 * time.Minute
+)
+
+// isEphemeral reports whether an event is only meaningful live and is never logged for replay
+func isEphemeral(eventType EventType) bool {
+	switch eventType {
+	case EventTypingStart, EventTypingStop, EventUserOnline, EventUserOffline, EventPresenceUpdated, EventPresenceSubscribed,
+		EventAck, EventError, EventResyncRequired, EventServerRestarting, EventSessionRevoked:
+		return true
+	}
+	return false
+}
+
+// logEvent appends an event to the log of each user and returns the sequence assigned to each one.
+// The caller must hold the users' event locks so sequences are delivered in the order they are assigned.
+func (h *Hub) logEvent(userIDs []string, message WSMessage) (map[string]int64, error) {
+	payload, err := json.Marshal(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		WITH seqs AS (
+			INSERT INTO user_event_sequences (user_id, last_seq)
+			SELECT DISTINCT unnest($1::uuid[]), 1
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_sequences.last_seq + 1
+			RETURNING user_id, last_seq
+		)
+		INSERT INTO user_events (user_id, seq, type, payload, created_at)
+		SELECT user_id, last_seq, $2, $3, $4 FROM seqs
+		RETURNING user_id, seq
+	`, userIDs, string(message.Type), payload, message.Timestamp)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seqs := make(map[string]int64, len(userIDs))
+	for rows.Next() {
+		var userID string
+		var seq int64
+		if err := rows.Scan(&userID, &seq); err != nil {
+			return nil, err
+		}
+		seqs[userID] = seq
+	}
+
+	return seqs, rows.Err()
+}
+
+// deliver logs a non-ephemeral event for each user, queues it on their local connections
+// and publishes it to the other replicas
+func (h *Hub) deliver(userIDs []string, message WSMessage) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	if isEphemeral(message.Type) {
+		h.deliverLocal(userIDs, message, nil)
+		h.publish(userIDs, message, nil)
+		return
+	}
+
+	// Held until the event is published, so other replicas also receive each
+	// user's events in sequence order
+	unlock := h.eventLocks.Lock(userIDs...)
+	defer unlock()
+
+	seqs, err := h.logEvent(userIDs, message)
+	if err != nil {
+		// Still deliver live; reconnecting clients will notice the missing sequence
+		log.Printf("Failed to log event %s: %v", message.Type, err)
+	}
+
+	h.deliverLocal(userIDs, message, seqs)
+	h.publish(userIDs, message, seqs)
+}
+
+// deliverLocal queues an event on the connections of each user held by this replica
+func (h *Hub) deliverLocal(userIDs []string, message WSMessage, seqs map[string]int64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// Ephemeral events carry no sequence, so they are encoded once per encoding
+	if seqs == nil {
+		encoded := newEncodedMessage(message)
+		droppable := isDroppable(message.Type)
+		for _, userID := range userIDs {
+			h.sendToUser(userID, encoded, droppable)
+		}
+		return
+	}
+
+	for _, userID := range userIDs {
+		if _, ok := h.Clients[userID]; !ok {
+			continue
+		}
+
+		userMessage := message
+		userMessage.Seq = seqs[userID]
+		encoded := newEncodedMessage(userMessage)
+
+		// Skip connections that already got this event in their replay
+		for client := range h.Clients[userID] {
+			if userMessage.Seq != 0 && userMessage.Seq <= client.replayedSeq {
+				continue
+			}
+
+			client.enqueueMessage(encoded, false)
+		}
+	}
+}
+
+// loadReplay returns the events a user missed after the given sequence.
+// It returns nil and resync true when some of them are no longer in the log.
+func (h *Hub) loadReplay(userID string, since int64) (messages []WSMessage, resync bool, lastSeq int64, err error) {
+	err = database.Pool.QueryRow(context.Background(), `
+		SELECT COALESCE((SELECT last_seq FROM user_event_sequences WHERE user_id = $1), 0)
+	`, userID).Scan(&lastSeq)
+
+	if err != nil {
+		return nil, false, 0, err
+	}
+
+	// Nothing missed, or a sequence this server never issued
+	if since >= lastSeq {
+		return nil, since > lastSeq, lastSeq, nil
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT seq, type, payload, created_at
+		FROM user_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq ASC
+		LIMIT $3
+	`, userID, since, MaxReplayEvents+1)
+
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer rows.Close()
+
+	expected := since + 1
+	for rows.Next() {
+		var message WSMessage
+		var eventType string
+		var payload json.RawMessage
+
+		if err := rows.Scan(&message.Seq, &eventType, &payload, &message.Timestamp); err != nil {
+			return nil, false, 0, err
+		}
+
+		// A hole means older events were pruned
+		if message.Seq != expected || len(messages) == MaxReplayEvents {
+			return nil, true, lastSeq, nil
+		}
+		expected++
+
+		message.Type = EventType(eventType)
+		message.Payload = payload
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, 0, err
+	}
+
+	if len(messages) == 0 {
+		return nil, true, lastSeq, nil
+	}
+
+	return messages, false, lastSeq, nil
+}
+
+// prepareReplay queues the events a reconnecting client missed ahead of live events,
+// or a resync_required event when they cannot all be replayed.
+// The caller must hold the user's event lock so no event is logged between the replay and registration.
+func (h *Hub) prepareReplay(client *Client) {
+	if client.Since < 0 {
+		return
+	}
+
+	messages, resync, lastSeq, err := h.loadReplay(client.ID, client.Since)
+	if err != nil {
+		log.Printf("Failed to load missed events for %s: %v", client.ID, err)
+		resync = true
+	}
+
+	if resync {
+		messages = []WSMessage{{
+			Type: EventResyncRequired,
+			Payload: ResyncPayload{
+				Since:   client.Since,
+				LastSeq: lastSeq,
+			},
+			Timestamp: time.Now(),
+		}}
+	}
+
+	frames := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		data, err := newEncodedMessage(message).encode(client.Encoding)
+		if err != nil {
+			log.Printf("Failed to encode missed event for %s: %v", client.ID, err)
+			continue
+		}
+		frames = append(frames, data)
+	}
+
+	client.pending = frames
+	client.replayedSeq = lastSeq
+}
+
+// pruneEventLog removes events older than the retention window
+func (h *Hub) pruneEventLog() {
+	result, err := database.Pool.Exec(context.Background(), `
+		DELETE FROM user_events WHERE created_at < $1
+	`, time.Now().Add(-h.EventRetention))
+
+	if err != nil {
+		log.Printf("Failed to prune event log: %v", err)
+		return
+	}
+
+	if result.RowsAffected() > 0 {
+		log.Printf("Pruned %d expired events", result.RowsAffected())
+	}
+}
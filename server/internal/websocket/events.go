@@ -1,6 +1,10 @@
 package websocket
 
-import "time"
+import (
+	"time"
+
+	"ngabarin/server/internal/models"
+)
 
 // EventType represents different WebSocket event types
 type EventType string
@@ -15,6 +19,12 @@ const (
 	EventMessageDelivered EventType = "message_delivered"
 	EventMessageRead      EventType = "message_read"
 	EventMessageReceived  EventType = "message_received"
+	EventMessageEdited    EventType = "message_edited"
+	EventMessageDeleted   EventType = "message_deleted"
+
+	// Reaction events
+	EventReactionAdded   EventType = "reaction_added"
+	EventReactionRemoved EventType = "reaction_removed"
 
 	// Group message events
 	EventGroupMessageSent     EventType = "group_message_sent"
@@ -25,8 +35,30 @@ const (
 	EventTypingStop  EventType = "typing_stop"
 
 	// Presence events
-	EventUserOnline  EventType = "user_online"
-	EventUserOffline EventType = "user_offline"
+	EventUserOnline      EventType = "user_online"
+	EventUserOffline     EventType = "user_offline"
+	EventPresenceUpdated EventType = "presence_updated" // The user's own status, sent to their devices
+	EventSetPresence     EventType = "set_presence"     // Client-to-server
+	EventActivity        EventType = "activity"         // Client-to-server
+
+	// Presence subscriptions for users who are not contacts
+	EventSubscribePresence   EventType = "subscribe_presence"   // Client-to-server
+	EventUnsubscribePresence EventType = "unsubscribe_presence" // Client-to-server
+	EventPresenceSubscribed  EventType = "presence_subscribed"
+
+	// Client-to-server send events
+	EventSendMessage      EventType = "send_message"
+	EventSendGroupMessage EventType = "send_group_message"
+	EventAck              EventType = "ack"
+
+	// Replay events
+	EventResyncRequired EventType = "resync_required"
+
+	// Server lifecycle events
+	EventServerRestarting EventType = "server_restarting"
+
+	// Session events
+	EventSessionRevoked EventType = "session_revoked"
 
 	// Error events
 	EventError EventType = "error"
@@ -37,28 +69,65 @@ type WSMessage struct {
 	Type      EventType   `json:"type"`
 	Payload   interface{} `json:"payload"`
 	Timestamp time.Time   `json:"timestamp"`
+	Seq       int64       `json:"seq,omitempty"` // Per-user sequence of replayable events
 }
 
 // MessagePayload represents message event payload
 type MessagePayload struct {
-	ID         string    `json:"id"`
-	ChatID     string    `json:"chatId"`
-	SenderID   string    `json:"senderId"`
-	ReceiverID string    `json:"receiverId"`
-	Content    string    `json:"content"`
-	Type       string    `json:"type"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"createdAt"`
+	ID         string               `json:"id"`
+	ChatID     string               `json:"chatId"`
+	SenderID   string               `json:"senderId"`
+	ReceiverID string               `json:"receiverId"`
+	Content    string               `json:"content"`
+	Type       string               `json:"type"`
+	Status     string               `json:"status"`
+	ReplyToID  string               `json:"replyToId,omitempty"`
+	ReplyTo    *models.ReplyPreview `json:"replyTo,omitempty"`
+	CreatedAt  time.Time            `json:"createdAt"`
+
+	ClientMessageID string `json:"clientMessageId,omitempty"`
 }
 
 // GroupMessagePayload represents group message event payload
 type GroupMessagePayload struct {
-	ID        string    `json:"id"`
-	GroupID   string    `json:"groupId"`
+	ID        string               `json:"id"`
+	GroupID   string               `json:"groupId"`
+	SenderID  string               `json:"senderId"`
+	Content   string               `json:"content"`
+	Type      string               `json:"type"`
+	ReplyToID string               `json:"replyToId,omitempty"`
+	ReplyTo   *models.ReplyPreview `json:"replyTo,omitempty"`
+	CreatedAt time.Time            `json:"createdAt"`
+
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+}
+
+// MessageEditedPayload represents message edited event payload
+type MessageEditedPayload struct {
+	MessageID string    `json:"messageId"`
+	ChatID    string    `json:"chatId,omitempty"`
+	GroupID   string    `json:"groupId,omitempty"`
 	SenderID  string    `json:"senderId"`
 	Content   string    `json:"content"`
-	Type      string    `json:"type"`
-	CreatedAt time.Time `json:"createdAt"`
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+// MessageDeletedPayload represents message deleted event payload
+type MessageDeletedPayload struct {
+	MessageID string    `json:"messageId"`
+	ChatID    string    `json:"chatId,omitempty"`
+	GroupID   string    `json:"groupId,omitempty"`
+	Scope     string    `json:"scope"` // me, everyone
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// ReactionPayload represents reaction added/removed event payload
+type ReactionPayload struct {
+	MessageID string `json:"messageId"`
+	ChatID    string `json:"chatId,omitempty"`
+	GroupID   string `json:"groupId,omitempty"`
+	UserID    string `json:"userId"`
+	Emoji     string `json:"emoji"`
 }
 
 // TypingPayload represents typing indicator payload
@@ -69,11 +138,39 @@ type TypingPayload struct {
 	UserName string `json:"userName"`
 }
 
-// PresencePayload represents user presence payload
+// PresencePayload represents user presence payload. Others see invisible users as offline;
+// only the user's own presence_updated events carry the invisible status.
 type PresencePayload struct {
-	UserID   string    `json:"userId"`
-	IsOnline bool      `json:"isOnline"`
-	LastSeen time.Time `json:"lastSeen,omitempty"`
+	UserID          string                `json:"userId"`
+	IsOnline        bool                  `json:"isOnline"`
+	Status          models.PresenceStatus `json:"status"` // online, idle, away, dnd, invisible or offline
+	StatusText      *string               `json:"statusText,omitempty"`
+	StatusExpiresAt *time.Time            `json:"statusExpiresAt,omitempty"`
+	LastSeen        *time.Time            `json:"lastSeen,omitempty"` // Omitted when hidden by privacy settings
+}
+
+// SetPresenceFrame represents the payload of set_presence frames and PUT /presence
+type SetPresenceFrame struct {
+	Status     models.PresenceStatus `json:"status"`
+	StatusText string                `json:"statusText,omitempty"`
+	ExpiresAt  *time.Time            `json:"expiresAt,omitempty"` // When the status and text revert to online
+}
+
+// ActivityFrame represents the payload of activity frames
+type ActivityFrame struct {
+	InactiveFor int64 `json:"inactiveFor"` // Seconds since the user last interacted with the client (0 = active)
+}
+
+// PresenceSubscriptionFrame represents the payload of subscribe_presence and unsubscribe_presence frames
+type PresenceSubscriptionFrame struct {
+	UserIDs []string `json:"userIds"` // Users to (un)follow; unsubscribe_presence without IDs drops every subscription
+}
+
+// PresenceSubscribedPayload answers subscribe_presence with the current presence of the followed users
+type PresenceSubscribedPayload struct {
+	UserIDs  []string          `json:"userIds"` // Users whose presence changes the client will receive
+	Denied   []string          `json:"denied"`  // Users the client shares no chat or group with
+	Presence []PresencePayload `json:"presence"`
 }
 
 // MessageStatusPayload represents message status update payload
@@ -85,8 +182,48 @@ type MessageStatusPayload struct {
 
 // ErrorPayload represents error event payload
 type ErrorPayload struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Set when the error answers a send frame
+}
+
+// Error makes ErrorPayload usable as an error value
+func (e *ErrorPayload) Error() string {
+	return e.Message
+}
+
+// SendMessageFrame represents the payload of send_message and send_group_message frames
+type SendMessageFrame struct {
+	ClientMessageID string `json:"clientMessageId"`
+	ReceiverID      string `json:"receiverId,omitempty"` // send_message only
+	GroupID         string `json:"groupId,omitempty"`    // send_group_message only
+	Content         string `json:"content"`
+	Type            string `json:"type"`
+	ReplyToID       string `json:"replyToId,omitempty"`
+}
+
+// AckPayload acknowledges a send frame once the message is stored
+type AckPayload struct {
+	ClientMessageID string    `json:"clientMessageId"`
+	MessageID       string    `json:"messageId"`
+	Status          string    `json:"status"` // sent, delivered
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// ResyncPayload tells a reconnecting client that missed events can no longer be replayed
+type ResyncPayload struct {
+	Since   int64 `json:"since"`   // Sequence the client asked to resume from
+	LastSeq int64 `json:"lastSeq"` // Latest sequence; resume from here after refetching over REST
+}
+
+// ServerRestartingPayload tells a client the server is shutting down and it should reconnect
+type ServerRestartingPayload struct {
+	RetryAfter int64 `json:"retryAfter"` // Suggested delay before reconnecting, in milliseconds
+}
+
+// SessionRevokedPayload tells a client its login session was revoked; it must log in again
+type SessionRevokedPayload struct {
+	SessionID string `json:"sessionId"`
 }
 
 // IncomingMessage represents messages received from clients
@@ -2,18 +2,30 @@ package websocket
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
+// MessageService stores chat messages sent over the WebSocket, using the same
+// validation and persistence as the REST API. Errors of type *ErrorPayload are
+// reported to the client as is.
+type MessageService interface {
+	SendDirectMessage(senderID string, frame SendMessageFrame) (*AckPayload, error)
+	SendGroupMessage(senderID string, frame SendMessageFrame) (*AckPayload, error)
+}
+
 // Hub maintains the set of active clients and broadcasts messages
 type Hub struct {
-	// Registered clients mapped by user ID
-	Clients map[string]*Client
+	// Registered connections grouped by user ID (a user may be connected from several devices)
+	Clients map[string]map[*Client]bool
 
 	// Register requests from clients
 	Register chan *Client
@@ -21,178 +33,356 @@ type Hub struct {
 	// Unregister requests from clients
 	Unregister chan *Client
 
+	// Messages handles send frames from clients (nil disables sending over the socket)
+	Messages MessageService
+
+	// EventRetention is how long events stay in the replay log
+	EventRetention time.Duration
+
+	// Broker fans events out to the other server replicas (nil for a single node)
+	Broker Broker
+
+	// NodeID identifies this replica on the broker
+	NodeID string
+
+	// Presence tracks which users are online across replicas
+	Presence PresenceStore
+
+	// PresenceHeartbeat is how often this replica refreshes its users' presence
+	PresenceHeartbeat time.Duration
+
+	// SendQueueSize is how many frames may wait for each client
+	SendQueueSize int
+
+	// DropPolicy decides which frames to discard for clients that fall behind
+	DropPolicy DropPolicy
+
+	// Metrics counts queued and dropped frames
+	Metrics Metrics
+
+	// Authorizer checks inbound events against relationships, memberships and blocks
+	Authorizer Authorizer
+
+	// TypingThrottle is the minimum interval between typing_start events a user sends to one chat
+	TypingThrottle time.Duration
+
+	// IdleAfter is how long a user's connections must report inactivity before they go idle
+	IdleAfter time.Duration
+
+	// Tracks the last typing event per user and chat
+	typing *typingThrottle
+
+	// Connections following the presence of users who are not their contacts
+	presenceSubs *presenceSubscriptions
+
 	// Mutex for thread-safe operations
 	mu sync.RWMutex
+
+	// Per-user locks serializing the logging and delivery of each user's replayable
+	// events, so sequences arrive in order and none slip past a replay
+	eventLocks *keyedMutex
+
+	// Per-user locks so each user's connects and disconnects are handled in order
+	connLocks *keyedMutex
+
+	// Set by Shutdown (while holding mu); new clients are turned away
+	draining atomic.Bool
+
+	// Cancelled by Shutdown to stop the broker subscription
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewHub creates a new WebSocket hub
 func NewHub() *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Hub{
-		Clients:    make(map[string]*Client),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Clients:           make(map[string]map[*Client]bool),
+		Register:          make(chan *Client),
+		Unregister:        make(chan *Client),
+		EventRetention:    DefaultEventRetention,
+		NodeID:            uuid.New().String(),
+		Presence:          DatabasePresence{},
+		PresenceHeartbeat: DefaultPresenceTTL / 3,
+		SendQueueSize:     DefaultSendQueueSize,
+		DropPolicy:        DropOldest,
+		Authorizer:        DatabaseAuthorizer{},
+		TypingThrottle:    DefaultTypingThrottle,
+		IdleAfter:         DefaultIdleAfter,
+		typing:            newTypingThrottle(),
+		presenceSubs:      newPresenceSubscriptions(),
+		eventLocks:        newKeyedMutex(),
+		connLocks:         newKeyedMutex(),
+		ctx:               ctx,
+		cancel:            cancel,
 	}
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
+	pruneTicker := time.NewTicker(eventLogPruneInterval)
+	defer pruneTicker.Stop()
+
+	heartbeatTicker := time.NewTicker(h.PresenceHeartbeat)
+	defer heartbeatTicker.Stop()
+
+	// Deliver events published by other replicas
+	if h.Broker != nil {
+		go h.subscribe()
+	}
+
 	for {
 		select {
 		case client := <-h.Register:
-			h.registerClient(client)
+			// Registration queries the event log and presence, so it must not hold up the loop
+			go h.registerClient(client)
 		case client := <-h.Unregister:
-			h.unregisterClient(client)
+			go h.unregisterClient(client)
+		case <-pruneTicker.C:
+			if h.draining.Load() {
+				continue
+			}
+			go h.pruneEventLog()
+			h.typing.prune(h.TypingThrottle)
+		case <-heartbeatTicker.C:
+			if h.draining.Load() {
+				continue
+			}
+			go h.heartbeatPresence()
+			go h.idleInactiveUsers()
+			go h.expireStatuses()
 		}
 	}
 }
 
 // registerClient adds a client to the hub
 func (h *Hub) registerClient(client *Client) {
+	unlock := h.connLocks.Lock(client.ID)
+	defer unlock()
+
+	// Receive events for this user from other replicas before going live
+	if watcher, ok := h.Broker.(UserWatcher); ok && !h.hasLocalConnections(client.ID) {
+		if err := watcher.WatchUser(client.ID); err != nil {
+			log.Printf("Failed to watch events for %s: %v", client.ID, err)
+		}
+	}
+
+	// Queue missed events before the client can receive live ones
+	unlockEvents := h.eventLocks.Lock(client.ID)
+	h.prepareReplay(client)
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	// If user already has a connection, close the old one
-	if existingClient, ok := h.Clients[client.ID]; ok {
-		close(existingClient.Send)
+	// The server is shutting down; send the client elsewhere
+	if h.draining.Load() {
+		h.mu.Unlock()
+		unlockEvents()
+		close(client.ready)
+		client.restart()
+		return
+	}
+
+	connections, ok := h.Clients[client.ID]
+	if !ok {
+		connections = make(map[*Client]bool)
+		h.Clients[client.ID] = connections
 	}
 
-	h.Clients[client.ID] = client
+	// A reconnect from the same device replaces its stale connection
+	var replaced []*Client
+	for existing := range connections {
+		if existing.DeviceID == client.DeviceID {
+			delete(connections, existing)
+			existing.Close(websocket.CloseNormalClosure, "replaced by a new connection")
+			replaced = append(replaced, existing)
+		}
+	}
 
-	// Update user's online status in database
-	_, err := database.Pool.Exec(context.Background(), `
-		UPDATE users SET is_online = true, last_seen = $1 WHERE id = $2
-	`, time.Now(), client.ID)
+	firstConnection := len(connections) == 0
+	connections[client] = true
 
-	if err != nil {
-		log.Printf("Failed to update online status: %v", err)
+	h.mu.Unlock()
+	unlockEvents()
+
+	// Let the write pump start with the replayed events
+	close(client.ready)
+
+	// Replaced connections never reach unregisterClient
+	for _, existing := range replaced {
+		h.unsubscribePresence(existing, nil)
+	}
+
+	log.Printf("Client connected: %s (%s) device %s", client.UniqueID, client.ID, client.DeviceID)
+
+	// Update user's online status. A user already connected to another replica
+	// was announced online there.
+	announce := false
+	if firstConnection {
+		online, err := h.Presence.OnlineUsers(context.Background(), []string{client.ID})
+		if err != nil {
+			logPresenceError("check online status", err)
+		}
+		announce = !online[client.ID]
+
+		if err := h.Presence.Connect(context.Background(), client.ID); err != nil {
+			logPresenceError("update online status", err)
+		}
+	}
+
+	// A new connection is activity, so a user the server set idle is back online
+	// (which is announced). Otherwise other devices already made the user online.
+	if err := h.Presence.ReportActivity(context.Background(), map[string]bool{client.ID: true}); err != nil {
+		logPresenceError("report activity", err)
+	}
+	if h.wakeFromIdle(client.ID) || !announce {
+		return
 	}
 
 	// Broadcast user online status to their contacts
 	h.broadcastPresence(client.ID, true)
-
-	log.Printf("Client connected: %s (%s)", client.UniqueID, client.ID)
 }
 
 // unregisterClient removes a client from the hub
 func (h *Hub) unregisterClient(client *Client) {
+	// A registration still in progress would add the client back afterwards
+	<-client.ready
+
+	unlock := h.connLocks.Lock(client.ID)
+	defer unlock()
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	if _, ok := h.Clients[client.ID]; ok {
+	connections, ok := h.Clients[client.ID]
+	if !ok || !connections[client] {
+		h.mu.Unlock()
+		return
+	}
+
+	delete(connections, client)
+	client.Close(websocket.CloseNormalClosure, "")
+
+	lastConnection := len(connections) == 0
+	if lastConnection {
 		delete(h.Clients, client.ID)
-		close(client.Send)
+	}
 
-		// Update user's offline status in database
-		_, err := database.Pool.Exec(context.Background(), `
-			UPDATE users SET is_online = false, last_seen = $1 WHERE id = $2
-		`, time.Now(), client.ID)
+	h.mu.Unlock()
 
-		if err != nil {
-			log.Printf("Failed to update offline status: %v", err)
+	h.unsubscribePresence(client, nil)
+
+	log.Printf("Client disconnected: %s (%s) device %s", client.UniqueID, client.ID, client.DeviceID)
+
+	// The user stays online while any other device is connected
+	if !lastConnection {
+		return
+	}
+
+	if watcher, ok := h.Broker.(UserWatcher); ok {
+		if err := watcher.UnwatchUser(client.ID); err != nil {
+			log.Printf("Failed to unwatch events for %s: %v", client.ID, err)
 		}
+	}
 
-		// Broadcast user offline status to their contacts
-		h.broadcastPresence(client.ID, false)
+	// Update user's offline status
+	if err := h.Presence.Disconnect(context.Background(), client.ID); err != nil {
+		logPresenceError("update offline status", err)
+	}
 
-		log.Printf("Client disconnected: %s (%s)", client.UniqueID, client.ID)
+	// The user may still be connected to another replica
+	online, err := h.Presence.OnlineUsers(context.Background(), []string{client.ID})
+	if err == nil && online[client.ID] {
+		return
 	}
+
+	// Broadcast user offline status to their contacts
+	h.broadcastPresence(client.ID, false)
 }
 
 // broadcastPresence sends user's online/offline status to their contacts
 func (h *Hub) broadcastPresence(userID string, isOnline bool) {
-	// Get user's contacts
-	rows, err := database.Pool.Query(context.Background(), `
-		SELECT user_id FROM contacts WHERE contact_id = $1
-		UNION
-		SELECT contact_id FROM contacts WHERE user_id = $1
-	`, userID)
-
+	user, err := loadPresence(userID, isOnline)
 	if err != nil {
-		log.Printf("Failed to get contacts: %v", err)
+		log.Printf("Failed to load presence for %s: %v", userID, err)
 		return
 	}
-	defer rows.Close()
 
-	// Prepare presence message
-	message := WSMessage{
-		Type: EventUserOnline,
-		Payload: PresencePayload{
-			UserID:   userID,
-			IsOnline: isOnline,
-			LastSeen: time.Now(),
-		},
-		Timestamp: time.Now(),
-	}
+	h.sendPresence(user)
+}
 
-	if !isOnline {
-		message.Type = EventUserOffline
-	}
+// sendPresence sends a user's presence, as others see it, to their contacts and
+// to the connections subscribed to it. Invisible users are announced as offline,
+// and the user's privacy settings decide what each viewer sees.
+func (h *Hub) sendPresence(user *models.User) {
+	// Get user's contacts, noting which are in the user's own contact list
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT viewer_id, bool_or(is_contact) FROM (
+			SELECT contact_id AS viewer_id, true AS is_contact FROM contacts WHERE user_id = $1
+			UNION ALL
+			SELECT user_id, false FROM contacts WHERE contact_id = $1
+		) viewers
+		GROUP BY viewer_id
+	`, user.ID)
 
-	data, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("Failed to marshal presence message: %v", err)
+		log.Printf("Failed to get contacts: %v", err)
 		return
 	}
 
-	// Send to each contact who is online
+	var contactIDs, otherIDs []string
 	for rows.Next() {
-		var contactID string
-		if err := rows.Scan(&contactID); err != nil {
+		var viewerID string
+		var isContact bool
+		if err := rows.Scan(&viewerID, &isContact); err != nil {
 			continue
 		}
 
-		h.mu.RLock()
-		if client, ok := h.Clients[contactID]; ok {
-			select {
-			case client.Send <- data:
-			default:
-				log.Printf("Failed to send presence to client: %s", contactID)
-			}
+		if isContact {
+			contactIDs = append(contactIDs, viewerID)
+		} else {
+			otherIDs = append(otherIDs, viewerID)
 		}
-		h.mu.RUnlock()
 	}
+	rows.Close()
+
+	// Send to each contact, wherever they are connected
+	if len(contactIDs) > 0 {
+		h.deliver(contactIDs, presenceMessage(user, true))
+	}
+	if len(otherIDs) > 0 {
+		h.deliver(otherIDs, presenceMessage(user, false))
+	}
+
+	// Subscribers are never contacts, so they see what everyone else does
+	h.publishPresence(user.ID, presenceMessage(user, false))
 }
 
-// BroadcastToUser sends a message to a specific user
-func (h *Hub) BroadcastToUser(userID string, message WSMessage) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// presenceMessage builds the presence event for viewers who are (or are not) in the user's contact list
+func presenceMessage(user *models.User, isContact bool) WSMessage {
+	response := user.ToResponse(isContact)
 
-	if client, ok := h.Clients[userID]; ok {
-		data, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Failed to marshal message: %v", err)
-			return
-		}
+	payload := presencePayload(response)
+	message := WSMessage{
+		Type:      EventUserOnline,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
 
-		select {
-		case client.Send <- data:
-		default:
-			log.Printf("Failed to send message to client: %s", userID)
-		}
+	if !payload.IsOnline {
+		message.Type = EventUserOffline
 	}
+
+	return message
+}
+
+// BroadcastToUser sends a message to all connections of a specific user
+func (h *Hub) BroadcastToUser(userID string, message WSMessage) {
+	h.deliver([]string{userID}, message)
 }
 
 // BroadcastToUsers sends a message to multiple users
 func (h *Hub) BroadcastToUsers(userIDs []string, message WSMessage) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
-	}
-
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	for _, userID := range userIDs {
-		if client, ok := h.Clients[userID]; ok {
-			select {
-			case client.Send <- data:
-			default:
-				log.Printf("Failed to send message to client: %s", userID)
-			}
-		}
-	}
+	h.deliver(userIDs, message)
 }
 
 // BroadcastToGroup sends a message to all members of a group
@@ -206,17 +396,8 @@ func (h *Hub) BroadcastToGroup(groupID string, message WSMessage, excludeUserID
 		log.Printf("Failed to get group members: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
-	}
-
-	h.mu.RLock()
-	defer h.mu.RUnlock()
 
+	var userIDs []string
 	for rows.Next() {
 		var userID string
 		if err := rows.Scan(&userID); err != nil {
@@ -228,18 +409,54 @@ func (h *Hub) BroadcastToGroup(groupID string, message WSMessage, excludeUserID
 			continue
 		}
 
-		if client, ok := h.Clients[userID]; ok {
-			select {
-			case client.Send <- data:
-			default:
-				log.Printf("Failed to send message to client: %s", userID)
-			}
-		}
+		userIDs = append(userIDs, userID)
 	}
+	rows.Close()
+
+	h.deliver(userIDs, message)
 }
 
-// IsUserOnline checks if a user is currently connected
+// sendToUser queues a message on every connection of a user.
+// The caller must hold h.mu.
+func (h *Hub) sendToUser(userID string, message *encodedMessage, droppable bool) {
+	for client := range h.Clients[userID] {
+		client.enqueueMessage(message, droppable)
+	}
+}
+
+// IsUserOnline checks if a user is currently connected to any replica
 func (h *Hub) IsUserOnline(userID string) bool {
+	if h.hasLocalConnections(userID) {
+		return true
+	}
+
+	online, err := h.Presence.OnlineUsers(context.Background(), []string{userID})
+	if err != nil {
+		logPresenceError("check online status", err)
+		return false
+	}
+
+	return online[userID]
+}
+
+// OnlineUsers reports which of the given users are connected to any replica
+func (h *Hub) OnlineUsers(userIDs []string) (map[string]bool, error) {
+	online, err := h.Presence.OnlineUsers(context.Background(), userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userID := range userIDs {
+		if h.hasLocalConnections(userID) {
+			online[userID] = true
+		}
+	}
+
+	return online, nil
+}
+
+// hasLocalConnections checks if a user is connected to this replica
+func (h *Hub) hasLocalConnections(userID string) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -260,10 +477,55 @@ func (h *Hub) GetOnlineUsers() []string {
 	return userIDs
 }
 
-// GetOnlineCount returns the number of currently connected clients
+// GetOnlineCount returns the number of currently connected users
 func (h *Hub) GetOnlineCount() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	return len(h.Clients)
 }
+
+// GetConnectionCount returns the number of open connections across all devices
+func (h *Hub) GetConnectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := 0
+	for _, connections := range h.Clients {
+		count += len(connections)
+	}
+
+	return count
+}
+
+// MetricsSnapshot returns the outbound frame counters and the frames currently queued
+func (h *Hub) MetricsSnapshot() MetricsSnapshot {
+	h.mu.RLock()
+	var pending int64
+	for _, connections := range h.Clients {
+		for client := range connections {
+			pending += int64(client.outbox.len())
+		}
+	}
+	h.mu.RUnlock()
+
+	return MetricsSnapshot{
+		FramesQueued:            h.Metrics.FramesQueued.Load(),
+		FramesDropped:           h.Metrics.FramesDropped.Load(),
+		FramesPending:           pending,
+		SlowConsumerDisconnects: h.Metrics.SlowConsumerDisconnects.Load(),
+	}
+}
+
+// GetUserDevices returns the device IDs a user is currently connected from
+func (h *Hub) GetUserDevices(userID string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	devices := make([]string, 0, len(h.Clients[userID]))
+	for client := range h.Clients[userID] {
+		devices = append(devices, client.DeviceID)
+	}
+
+	return devices
+}
@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"slices"
+	"sync"
+)
+
+// keyedMutex holds a mutex per key (a user ID), created on first use and
+// removed once nobody holds or waits for it
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the mutex of one key and how many callers hold or wait for it
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// newKeyedMutex creates an empty keyedMutex
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock locks the given keys and returns the function that unlocks them. Keys are
+// locked in sorted order, so callers locking overlapping sets cannot deadlock.
+func (m *keyedMutex) Lock(keys ...string) (unlock func()) {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+
+	m.mu.Lock()
+	locks := make([]*keyedLock, len(keys))
+	for i, key := range keys {
+		lock, ok := m.locks[key]
+		if !ok {
+			lock = &keyedLock{}
+			m.locks[key] = lock
+		}
+		lock.refs++
+		locks[i] = lock
+	}
+	m.mu.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+
+	return func() {
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+
+		m.mu.Lock()
+		for i, key := range keys {
+			if locks[i].refs--; locks[i].refs == 0 {
+				delete(m.locks, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// DropPolicy decides what happens when a client's outbound queue is full
+type DropPolicy string
+
+const (
+	// DropOldest discards the oldest queued typing/presence frame to make room
+	DropOldest DropPolicy = "drop_oldest"
+
+	// DropNewest discards the incoming typing/presence frame
+	DropNewest DropPolicy = "drop_newest"
+
+	// DropNone never discards frames and disconnects the client instead
+	DropNone DropPolicy = "none"
+)
+
+const (
+	// DefaultSendQueueSize is how many frames may wait for a client before the drop policy applies
+	DefaultSendQueueSize = 256
+
+	// CloseSlowConsumer is sent when a client cannot keep up with its frames.
+	// Clients should reconnect with ?since=<seq> to catch up.
+	CloseSlowConsumer = websocket.CloseTryAgainLater
+)
+
+// ParseDropPolicy returns the named policy, or DropOldest for an unknown name
+func ParseDropPolicy(name string) DropPolicy {
+	switch DropPolicy(name) {
+	case DropNewest, DropNone:
+		return DropPolicy(name)
+	}
+	return DropOldest
+}
+
+// isDroppable reports whether an event may be discarded for a slow client.
+// Chat messages and their updates are never dropped.
+func isDroppable(eventType EventType) bool {
+	switch eventType {
+	case EventTypingStart, EventTypingStop, EventUserOnline, EventUserOffline:
+		return true
+	}
+	return false
+}
+
+// Metrics counts outbound frames across all clients
+type Metrics struct {
+	FramesQueued            atomic.Int64
+	FramesDropped           atomic.Int64
+	SlowConsumerDisconnects atomic.Int64
+}
+
+// MetricsSnapshot is a point-in-time copy of Metrics
+type MetricsSnapshot struct {
+	FramesQueued            int64 `json:"framesQueued"`
+	FramesDropped           int64 `json:"framesDropped"`
+	FramesPending           int64 `json:"framesPending"`
+	SlowConsumerDisconnects int64 `json:"slowConsumerDisconnects"`
+}
+
+// outboundFrame is an encoded frame waiting to be written
+type outboundFrame struct {
+	data      []byte
+	droppable bool
+}
+
+// outbox is a client's bounded outbound queue. It is the only place that decides
+// when a connection is closed, so closing twice is harmless.
+type outbox struct {
+	mu      sync.Mutex
+	frames  []outboundFrame
+	limit   int
+	policy  DropPolicy
+	metrics *Metrics
+
+	// notify is signalled whenever frames are added or the outbox is closed
+	notify chan struct{}
+
+	closed      bool
+	closeCode   int
+	closeReason string
+}
+
+// newOutbox creates an outbox holding at most limit frames
+func newOutbox(limit int, policy DropPolicy, metrics *Metrics) *outbox {
+	if limit <= 0 {
+		limit = DefaultSendQueueSize
+	}
+
+	return &outbox{
+		limit:   limit,
+		policy:  policy,
+		metrics: metrics,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame, applying the drop policy when the queue is full.
+// It returns false if the frame was not queued.
+func (o *outbox) push(data []byte, droppable bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return false
+	}
+
+	if len(o.frames) >= o.limit && !o.makeRoom() {
+		// Under DropNone nothing is discarded, so every overflow disconnects the client
+		if droppable && o.policy != DropNone {
+			o.metrics.FramesDropped.Add(1)
+			return false
+		}
+
+		// Never drop a message; disconnect the client so it reconnects and replays
+		o.metrics.SlowConsumerDisconnects.Add(1)
+		o.metrics.FramesDropped.Add(int64(len(o.frames)) + 1)
+		o.frames = nil
+		o.closeLocked(CloseSlowConsumer, "slow consumer")
+		return false
+	}
+
+	o.frames = append(o.frames, outboundFrame{data: data, droppable: droppable})
+	o.metrics.FramesQueued.Add(1)
+	o.signal()
+	return true
+}
+
+// makeRoom frees a slot for a new frame according to the drop policy.
+// The caller must hold o.mu.
+func (o *outbox) makeRoom() bool {
+	if o.policy != DropOldest {
+		return false
+	}
+
+	for i, frame := range o.frames {
+		if frame.droppable {
+			o.frames = append(o.frames[:i], o.frames[i+1:]...)
+			o.metrics.FramesDropped.Add(1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// drain takes every queued frame. closed is true once the outbox has been closed,
+// after which the caller should write the close frame and stop.
+func (o *outbox) drain() (frames []outboundFrame, closed bool, code int, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	frames = o.frames
+	o.frames = nil
+	return frames, o.closed, o.closeCode, o.closeReason
+}
+
+// close stops accepting frames; frames already queued are still written
+func (o *outbox) close(code int, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.closeLocked(code, reason)
+}
+
+// closeLocked closes the outbox. The caller must hold o.mu.
+func (o *outbox) closeLocked(code int, reason string) {
+	if o.closed {
+		return
+	}
+
+	o.closed = true
+	o.closeCode = code
+	o.closeReason = reason
+	o.signal()
+}
+
+// len returns the number of queued frames
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.frames)
+}
+
+// signal wakes the write pump without blocking
+func (o *outbox) signal() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ngabarin/server/internal/database"
+)
+
+// PresenceStore tracks which users are online across all replicas
+type PresenceStore interface {
+	// Connect marks a user online from this replica
+	Connect(ctx context.Context, userID string) error
+
+	// Disconnect marks a user no longer connected to this replica
+	Disconnect(ctx context.Context, userID string) error
+
+	// DisconnectAll marks many users no longer connected to this replica at once
+	DisconnectAll(ctx context.Context, userIDs []string) error
+
+	// Heartbeat keeps the users connected to this replica online
+	Heartbeat(ctx context.Context, userIDs []string) error
+
+	// OnlineUsers reports which of the given users are online anywhere
+	OnlineUsers(ctx context.Context, userIDs []string) (map[string]bool, error)
+
+	// ReportActivity records, per user, whether any of their connections to this
+	// replica is active
+	ReportActivity(ctx context.Context, activity map[string]bool) error
+
+	// ActiveUsers reports which of the given users are active on any replica
+	ActiveUsers(ctx context.Context, userIDs []string) (map[string]bool, error)
+}
+
+// DatabasePresence stores online state in the users.is_online column. last_seen is
+// left alone while a user is invisible so it does not reveal their activity.
+type DatabasePresence struct{}
+
+// Connect sets is_online for the user
+func (DatabasePresence) Connect(ctx context.Context, userID string) error {
+	_, err := database.Pool.Exec(ctx, `
+		UPDATE users SET is_online = true, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = $2
+	`, time.Now(), userID)
+	return err
+}
+
+// Disconnect clears is_online for the user
+func (DatabasePresence) Disconnect(ctx context.Context, userID string) error {
+	_, err := database.Pool.Exec(ctx, `
+		UPDATE users SET is_online = false, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = $2
+	`, time.Now(), userID)
+	return err
+}
+
+// DisconnectAll clears is_online for every given user in one update
+func (DatabasePresence) DisconnectAll(ctx context.Context, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	_, err := database.Pool.Exec(ctx, `
+		UPDATE users SET is_online = false, last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = ANY($2::uuid[])
+	`, time.Now(), userIDs)
+	return err
+}
+
+// Heartbeat does nothing; the column stays set until the user disconnects
+func (DatabasePresence) Heartbeat(ctx context.Context, userIDs []string) error {
+	return nil
+}
+
+// OnlineUsers reads is_online for the given users
+func (DatabasePresence) OnlineUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	online := make(map[string]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	rows, err := database.Pool.Query(ctx, `
+		SELECT id FROM users WHERE id = ANY($1::uuid[]) AND is_online = true
+	`, userIDs)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		online[userID] = true
+	}
+
+	return online, rows.Err()
+}
+
+// ReportActivity does nothing; like is_online, activity is only known to this replica
+func (DatabasePresence) ReportActivity(ctx context.Context, activity map[string]bool) error {
+	return nil
+}
+
+// ActiveUsers reports no activity beyond what this replica sees itself
+func (DatabasePresence) ActiveUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+// heartbeatPresence refreshes the presence of every user connected to this replica
+func (h *Hub) heartbeatPresence() {
+	if err := h.Presence.Heartbeat(context.Background(), h.GetOnlineUsers()); err != nil {
+		logPresenceError("refresh", err)
+	}
+}
+
+// logPresenceError logs a failed presence operation
+func logPresenceError(action string, err error) {
+	log.Printf("Failed to %s: %v", action, err)
+}
@@ -0,0 +1,339 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"ngabarin/server/internal/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisUserChannelPrefix prefixes the pub/sub channel of each user
+	redisUserChannelPrefix = "ngabarin:user:"
+
+	// redisPresenceChannelPrefix prefixes the pub/sub channel of each user's presence changes
+	redisPresenceChannelPrefix = "ngabarin:presence-changes:"
+
+	// redisPresenceKeyPrefix prefixes the sorted set of replicas a user is connected to
+	redisPresenceKeyPrefix = "ngabarin:presence:"
+
+	// redisActivityKeyPrefix prefixes the sorted set of replicas where a user is active
+	redisActivityKeyPrefix = "ngabarin:activity:"
+
+	// DefaultPresenceTTL is how long a replica's presence entry lives without a heartbeat
+	DefaultPresenceTTL = 60 * time.Second
+)
+
+// RedisBroker fans events out over Redis pub/sub. Every user has a channel, and each
+// replica only subscribes to the channels of users connected to it. Group events are
+// resolved to their members before publishing, so membership stays in Postgres.
+// Presence changes go on a second channel per user, subscribed to by the replicas
+// with connections following that user.
+type RedisBroker struct {
+	client redis.UniversalClient
+	pubsub *redis.PubSub
+
+	mu       sync.Mutex
+	watching map[string]bool // Subscribed channels
+}
+
+// NewRedisBroker creates a broker on the given client (a redis-server or an in-process fake)
+func NewRedisBroker(client redis.UniversalClient) *RedisBroker {
+	return &RedisBroker{
+		client:   client,
+		pubsub:   client.Subscribe(context.Background()),
+		watching: make(map[string]bool),
+	}
+}
+
+// redisUserChannel returns the pub/sub channel of a user
+func redisUserChannel(userID string) string {
+	return redisUserChannelPrefix + userID
+}
+
+// redisPresenceChannel returns the pub/sub channel of a user's presence changes
+func redisPresenceChannel(userID string) string {
+	return redisPresenceChannelPrefix + userID
+}
+
+// Publish sends the event on the channel of each recipient, or a presence change on the user's presence channel
+func (b *RedisBroker) Publish(ctx context.Context, envelope Envelope) error {
+	if envelope.Presence != "" {
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+
+		return b.client.Publish(ctx, redisPresenceChannel(envelope.Presence), data).Err()
+	}
+
+	pipe := b.client.Pipeline()
+
+	for _, userID := range envelope.UserIDs {
+		userEnvelope := Envelope{
+			Origin:   envelope.Origin,
+			UserIDs:  []string{userID},
+			Message:  envelope.Message,
+			Sessions: envelope.Sessions,
+		}
+		if seq, ok := envelope.Seqs[userID]; ok {
+			userEnvelope.Seqs = map[string]int64{userID: seq}
+		}
+
+		data, err := json.Marshal(userEnvelope)
+		if err != nil {
+			return err
+		}
+
+		pipe.Publish(ctx, redisUserChannel(userID), data)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Subscribe delivers events for watched users until ctx is cancelled.
+// The client resubscribes on its own after a lost connection.
+func (b *RedisBroker) Subscribe(ctx context.Context, handle func(Envelope)) error {
+	defer b.pubsub.Close()
+
+	messages := b.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			var envelope Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+				log.Printf("Failed to decode redis event: %v", err)
+				continue
+			}
+
+			handle(envelope)
+		}
+	}
+}
+
+// WatchUser subscribes to a user's channel once they connect to this replica
+func (b *RedisBroker) WatchUser(userID string) error {
+	return b.watch(redisUserChannel(userID))
+}
+
+// UnwatchUser unsubscribes from a user's channel after their last connection closes
+func (b *RedisBroker) UnwatchUser(userID string) error {
+	return b.unwatch(redisUserChannel(userID))
+}
+
+// WatchPresence subscribes to a user's presence changes once a local connection follows them
+func (b *RedisBroker) WatchPresence(userID string) error {
+	return b.watch(redisPresenceChannel(userID))
+}
+
+// UnwatchPresence unsubscribes from a user's presence changes after their last local follower goes
+func (b *RedisBroker) UnwatchPresence(userID string) error {
+	return b.unwatch(redisPresenceChannel(userID))
+}
+
+// watch subscribes to a channel unless already subscribed
+func (b *RedisBroker) watch(channel string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.watching[channel] {
+		return nil
+	}
+
+	if err := b.pubsub.Subscribe(context.Background(), channel); err != nil {
+		return err
+	}
+
+	b.watching[channel] = true
+	return nil
+}
+
+// unwatch unsubscribes from a channel
+func (b *RedisBroker) unwatch(channel string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.watching[channel] {
+		return nil
+	}
+
+	delete(b.watching, channel)
+	return b.pubsub.Unsubscribe(context.Background(), channel)
+}
+
+// RedisPresence keeps online state in Redis. Each user has a sorted set of the
+// replicas they are connected to, scored by when the entry expires, so a replica
+// that crashes stops counting once its heartbeats stop.
+type RedisPresence struct {
+	client redis.UniversalClient
+	nodeID string
+	ttl    time.Duration
+}
+
+// NewRedisPresence creates a presence store for the replica with the given node ID
+func NewRedisPresence(client redis.UniversalClient, nodeID string, ttl time.Duration) *RedisPresence {
+	if ttl <= 0 {
+		ttl = DefaultPresenceTTL
+	}
+
+	return &RedisPresence{
+		client: client,
+		nodeID: nodeID,
+		ttl:    ttl,
+	}
+}
+
+// redisPresenceKey returns the presence key of a user
+func redisPresenceKey(userID string) string {
+	return redisPresenceKeyPrefix + userID
+}
+
+// redisActivityKey returns the activity key of a user
+func redisActivityKey(userID string) string {
+	return redisActivityKeyPrefix + userID
+}
+
+// TTL returns how long an entry lives without a heartbeat
+func (p *RedisPresence) TTL() time.Duration {
+	return p.ttl
+}
+
+// Connect adds this replica to the user's presence set
+func (p *RedisPresence) Connect(ctx context.Context, userID string) error {
+	return p.Heartbeat(ctx, []string{userID})
+}
+
+// Disconnect removes this replica from the user's presence set and records last_seen
+func (p *RedisPresence) Disconnect(ctx context.Context, userID string) error {
+	pipe := p.client.Pipeline()
+	pipe.ZRem(ctx, redisPresenceKey(userID), p.nodeID)
+	pipe.ZRem(ctx, redisActivityKey(userID), p.nodeID)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	_, err := database.Pool.Exec(ctx, `
+		UPDATE users SET last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = $2
+	`, time.Now(), userID)
+	return err
+}
+
+// DisconnectAll removes this replica from the presence set of every given user
+func (p *RedisPresence) DisconnectAll(ctx context.Context, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	pipe := p.client.Pipeline()
+	for _, userID := range userIDs {
+		pipe.ZRem(ctx, redisPresenceKey(userID), p.nodeID)
+		pipe.ZRem(ctx, redisActivityKey(userID), p.nodeID)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	_, err := database.Pool.Exec(ctx, `
+		UPDATE users SET last_seen = CASE WHEN presence_status = 'invisible' THEN last_seen ELSE $1 END WHERE id = ANY($2::uuid[])
+	`, time.Now(), userIDs)
+	return err
+}
+
+// Heartbeat extends this replica's entry for each user
+func (p *RedisPresence) Heartbeat(ctx context.Context, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	expiresAt := float64(now.Add(p.ttl).UnixMilli())
+
+	pipe := p.client.Pipeline()
+	for _, userID := range userIDs {
+		key := redisPresenceKey(userID)
+		pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: p.nodeID})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		pipe.PExpire(ctx, key, p.ttl)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// OnlineUsers reports users with at least one unexpired replica entry
+func (p *RedisPresence) OnlineUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	return p.withEntries(ctx, redisPresenceKey, userIDs)
+}
+
+// ReportActivity adds this replica to the activity set of users active here and
+// removes it for users inactive here. Entries expire like presence entries, so
+// a replica that crashes stops keeping its users active.
+func (p *RedisPresence) ReportActivity(ctx context.Context, activity map[string]bool) error {
+	if len(activity) == 0 {
+		return nil
+	}
+
+	expiresAt := float64(time.Now().Add(p.ttl).UnixMilli())
+
+	pipe := p.client.Pipeline()
+	for userID, active := range activity {
+		key := redisActivityKey(userID)
+		if !active {
+			pipe.ZRem(ctx, key, p.nodeID)
+			continue
+		}
+		pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: p.nodeID})
+		pipe.PExpire(ctx, key, p.ttl)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ActiveUsers reports users with at least one unexpired activity entry
+func (p *RedisPresence) ActiveUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	return p.withEntries(ctx, redisActivityKey, userIDs)
+}
+
+// withEntries reports users whose sorted set under key has an unexpired replica entry
+func (p *RedisPresence) withEntries(ctx context.Context, key func(string) string, userIDs []string) (map[string]bool, error) {
+	found := make(map[string]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return found, nil
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	pipe := p.client.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.ZCount(ctx, key(userID), "("+now, "+inf")
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, userID := range userIDs {
+		if counts[i].Val() > 0 {
+			found[userID] = true
+		}
+	}
+
+	return found, nil
+}
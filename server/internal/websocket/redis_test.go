@@ -0,0 +1,258 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis starts an in-process Redis server and returns a client connected to it
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return server, client
+}
+
+// subscribeBroker runs the broker's subscription until the test ends and returns the received envelopes
+func subscribeBroker(t *testing.T, broker *RedisBroker) <-chan Envelope {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	received := make(chan Envelope, 16)
+	go broker.Subscribe(ctx, func(envelope Envelope) {
+		received <- envelope
+	})
+
+	return received
+}
+
+// waitSubscribed waits until the channel has the given number of subscribers
+func waitSubscribed(t *testing.T, server *miniredis.Miniredis, channel string, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.PubSubNumSub(channel)[channel] != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("channel %s has %d subscribers, want %d", channel, server.PubSubNumSub(channel)[channel], want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// expectEnvelope returns the next received envelope, failing the test after a timeout
+func expectEnvelope(t *testing.T, received <-chan Envelope) Envelope {
+	t.Helper()
+
+	select {
+	case envelope := <-received:
+		return envelope
+	case <-time.After(2 * time.Second):
+		t.Fatal("no envelope received")
+		return Envelope{}
+	}
+}
+
+// expectNoEnvelope fails the test if an envelope arrives shortly
+func expectNoEnvelope(t *testing.T, received <-chan Envelope) {
+	t.Helper()
+
+	select {
+	case envelope := <-received:
+		t.Fatalf("unexpected envelope for %v (presence %q)", envelope.UserIDs, envelope.Presence)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedisBrokerDeliversToWatchingReplica(t *testing.T) {
+	server, client := newTestRedis(t)
+
+	sender := NewRedisBroker(client)
+	receiver := NewRedisBroker(client)
+	received := subscribeBroker(t, receiver)
+
+	if err := receiver.WatchUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, redisUserChannel("alice"), 1)
+
+	message := json.RawMessage(`{"type":"message_received"}`)
+	err := sender.Publish(context.Background(), Envelope{
+		Origin:  "node-a",
+		UserIDs: []string{"alice", "bob"},
+		Message: message,
+		Seqs:    map[string]int64{"alice": 7, "bob": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the watched user's copy arrives, with only their sequence
+	envelope := expectEnvelope(t, received)
+	if len(envelope.UserIDs) != 1 || envelope.UserIDs[0] != "alice" {
+		t.Fatalf("recipients = %v, want [alice]", envelope.UserIDs)
+	}
+	if len(envelope.Seqs) != 1 || envelope.Seqs["alice"] != 7 {
+		t.Fatalf("seqs = %v, want alice:7", envelope.Seqs)
+	}
+	if envelope.Origin != "node-a" || string(envelope.Message) != string(message) {
+		t.Fatalf("envelope = %+v", envelope)
+	}
+	expectNoEnvelope(t, received)
+
+	// Nothing arrives after the user's last local connection closes
+	if err := receiver.UnwatchUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, redisUserChannel("alice"), 0)
+
+	if err := sender.Publish(context.Background(), Envelope{UserIDs: []string{"alice"}, Message: message}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEnvelope(t, received)
+}
+
+func TestRedisBrokerPresenceChannel(t *testing.T) {
+	server, client := newTestRedis(t)
+
+	sender := NewRedisBroker(client)
+	receiver := NewRedisBroker(client)
+	received := subscribeBroker(t, receiver)
+
+	// Watching a user's events does not subscribe to their presence changes
+	if err := receiver.WatchUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, redisUserChannel("alice"), 1)
+
+	presence := Envelope{Presence: "alice", Message: json.RawMessage(`{"type":"user_online"}`)}
+	if err := sender.Publish(context.Background(), presence); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEnvelope(t, received)
+
+	if err := receiver.WatchPresence("alice"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, redisPresenceChannel("alice"), 1)
+
+	if err := sender.Publish(context.Background(), presence); err != nil {
+		t.Fatal(err)
+	}
+	if envelope := expectEnvelope(t, received); envelope.Presence != "alice" {
+		t.Fatalf("presence = %q, want alice", envelope.Presence)
+	}
+
+	// Watching twice subscribes once
+	if err := receiver.WatchPresence("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.UnwatchPresence("alice"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, redisPresenceChannel("alice"), 0)
+}
+
+func TestRedisPresenceAcrossReplicas(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	nodeA := NewRedisPresence(client, "node-a", time.Minute)
+	nodeB := NewRedisPresence(client, "node-b", time.Minute)
+
+	if err := nodeA.Connect(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeB.Connect(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Either replica sees users connected to the other
+	online, err := nodeB.OnlineUsers(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !online["alice"] || online["bob"] {
+		t.Fatalf("online = %v, want only alice", online)
+	}
+
+	// The user stays online while another replica holds a connection
+	if err := client.ZRem(ctx, redisPresenceKey("alice"), "node-a").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if online, _ := nodeA.OnlineUsers(ctx, []string{"alice"}); !online["alice"] {
+		t.Fatal("alice went offline while connected to node-b")
+	}
+}
+
+func TestRedisPresenceExpiresWithoutHeartbeat(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	crashed := NewRedisPresence(client, "node-a", 50*time.Millisecond)
+	live := NewRedisPresence(client, "node-b", 50*time.Millisecond)
+
+	if err := crashed.Connect(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := live.Connect(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the replica that keeps sending heartbeats keeps its users online
+	for i := 0; i < 4; i++ {
+		time.Sleep(25 * time.Millisecond)
+		if err := live.Heartbeat(ctx, []string{"bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	online, err := live.OnlineUsers(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if online["alice"] || !online["bob"] {
+		t.Fatalf("online = %v, want only bob", online)
+	}
+}
+
+func TestRedisActivityAcrossReplicas(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	nodeA := NewRedisPresence(client, "node-a", time.Minute)
+	nodeB := NewRedisPresence(client, "node-b", time.Minute)
+
+	// alice is inactive on node-a but active on node-b, so she is not idle
+	if err := nodeA.ReportActivity(ctx, map[string]bool{"alice": false, "bob": false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeB.ReportActivity(ctx, map[string]bool{"alice": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := nodeA.ActiveUsers(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !active["alice"] || active["bob"] {
+		t.Fatalf("active = %v, want only alice", active)
+	}
+
+	// Once node-b reports her inactive too, she is active nowhere
+	if err := nodeB.ReportActivity(ctx, map[string]bool{"alice": false}); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := nodeA.ActiveUsers(ctx, []string{"alice"}); active["alice"] {
+		t.Fatal("alice still active after going inactive on node-b")
+	}
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"context"
+	"log"
+)
+
+// CloseSessions closes a user's connections, on every replica, that were opened
+// with one of the given sessions. Each client gets session_revoked first.
+func (h *Hub) CloseSessions(userID string, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	h.closeSessions(userID, sessionIDs)
+
+	if h.Broker == nil {
+		return
+	}
+
+	envelope := Envelope{
+		Origin:   h.NodeID,
+		UserIDs:  []string{userID},
+		Sessions: sessionIDs,
+	}
+
+	if err := h.Broker.Publish(context.Background(), envelope); err != nil {
+		log.Printf("Failed to publish revoked sessions of %s: %v", userID, err)
+	}
+}
+
+// closeSessions closes a user's local connections opened with one of the given sessions
+func (h *Hub) closeSessions(userID string, sessionIDs []string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		revoked[sessionID] = true
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// The connections unregister themselves once closed
+	for client := range h.Clients[userID] {
+		if revoked[client.SessionID] {
+			client.revoke()
+		}
+	}
+}
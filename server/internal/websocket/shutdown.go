@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// restartRetryMin is the shortest reconnect delay suggested to clients on shutdown
+	restartRetryMin = time.Second
+
+	// restartRetryJitter is the random spread added to the reconnect delay
+	restartRetryJitter = 4 * time.Second
+)
+
+// Shutdown drains the hub before the server stops. New clients are turned away,
+// every connected client gets a server_restarting event and a close frame, their
+// users are marked offline in one batch, and Shutdown waits for the write pumps
+// to flush until ctx is done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.draining.Store(true)
+	clients := h.Clients
+	h.Clients = make(map[string]map[*Client]bool)
+	h.mu.Unlock()
+
+	userIDs := make([]string, 0, len(clients))
+	for userID, connections := range clients {
+		userIDs = append(userIDs, userID)
+		for client := range connections {
+			client.restart()
+		}
+	}
+
+	log.Printf("Closing %d WebSocket users for shutdown", len(userIDs))
+
+	// Update offline status for everyone at once
+	if err := h.Presence.DisconnectAll(ctx, userIDs); err != nil {
+		logPresenceError("update offline status", err)
+	}
+
+	// Wait for queued frames and close frames to be written
+	var err error
+wait:
+	for _, connections := range clients {
+		for client := range connections {
+			if client.done == nil {
+				continue
+			}
+
+			select {
+			case <-client.done:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break wait
+			}
+		}
+	}
+
+	// Tell contacts connected to other replicas, unless the users are still online there
+	if online, onlineErr := h.Presence.OnlineUsers(ctx, userIDs); onlineErr == nil {
+		for _, userID := range userIDs {
+			if ctx.Err() != nil {
+				break
+			}
+			if !online[userID] {
+				h.broadcastPresence(userID, false)
+			}
+		}
+	}
+
+	// Stop receiving events from other replicas
+	h.cancel()
+
+	return err
+}
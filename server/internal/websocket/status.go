@@ -0,0 +1,257 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+)
+
+// DefaultIdleAfter is how long every connection of a user must report inactivity
+// before the server sets them idle
+const DefaultIdleAfter = 5 * time.Minute
+
+// SetPresence stores the status a user chose and tells their contacts and devices
+func (h *Hub) SetPresence(userID string, frame SetPresenceFrame) (*PresencePayload, *ErrorPayload) {
+	if !frame.Status.IsValid() {
+		return nil, &ErrorPayload{Code: "invalid_request", Message: "status must be online, idle, away, dnd or invisible"}
+	}
+
+	statusText := strings.TrimSpace(frame.StatusText)
+	if utf8.RuneCountInString(statusText) > models.MaxStatusTextLength {
+		return nil, &ErrorPayload{Code: "invalid_request", Message: "statusText is too long"}
+	}
+
+	if frame.ExpiresAt != nil && !frame.ExpiresAt.After(time.Now()) {
+		return nil, &ErrorPayload{Code: "invalid_request", Message: "expiresAt must be in the future"}
+	}
+
+	// last_seen moves to now, so going invisible looks like going offline
+	_, err := database.Pool.Exec(context.Background(), `
+		UPDATE users
+		SET presence_status = $1, presence_auto_idle = false, status_text = NULLIF($2, ''),
+			status_expires_at = $3, last_seen = $4, updated_at = $4
+		WHERE id = $5
+	`, frame.Status, statusText, frame.ExpiresAt, time.Now(), userID)
+
+	if err != nil {
+		log.Printf("Failed to set presence for %s: %v", userID, err)
+		return nil, &ErrorPayload{Code: "internal_error", Message: "Failed to set presence"}
+	}
+
+	presence, err := h.presenceChanged(userID)
+	if err != nil {
+		return nil, &ErrorPayload{Code: "internal_error", Message: "Failed to load presence"}
+	}
+
+	return presence, nil
+}
+
+// GetPresence returns a user's own presence, including the invisible status
+func (h *Hub) GetPresence(userID string) (*PresencePayload, error) {
+	user, err := loadPresence(userID, h.IsUserOnline(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	presence := presencePayload(user.ToOwnResponse())
+	return &presence, nil
+}
+
+// RefreshPresence re-sends a user's presence, e.g. after their privacy settings change
+func (h *Hub) RefreshPresence(userID string) {
+	h.presenceChanged(userID)
+}
+
+// presenceChanged sends a user's new status to their contacts and to their own devices
+func (h *Hub) presenceChanged(userID string) (*PresencePayload, error) {
+	user, err := loadPresence(userID, h.IsUserOnline(userID))
+	if err != nil {
+		log.Printf("Failed to load presence for %s: %v", userID, err)
+		return nil, err
+	}
+
+	own := presencePayload(user.ToOwnResponse())
+	h.BroadcastToUser(userID, WSMessage{
+		Type:      EventPresenceUpdated,
+		Payload:   own,
+		Timestamp: time.Now(),
+	})
+
+	h.sendPresence(user)
+	return &own, nil
+}
+
+// loadPresence reads the status a user chose, their last_seen and who may see them
+func loadPresence(userID string, isOnline bool) (*models.User, error) {
+	user := &models.User{ID: userID, IsOnline: isOnline}
+
+	err := database.Pool.QueryRow(context.Background(), `
+		SELECT presence_status, status_text, status_expires_at, last_seen,
+			privacy_last_seen, privacy_online
+		FROM users WHERE id = $1
+	`, userID).Scan(&user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen,
+		&user.Privacy.LastSeen, &user.Privacy.Online)
+
+	return user, err
+}
+
+// presencePayload builds a presence event from a user as it would appear in the REST API
+func presencePayload(user models.UserResponse) PresencePayload {
+	return PresencePayload{
+		UserID:          user.ID,
+		IsOnline:        user.IsOnline,
+		Status:          user.Status,
+		StatusText:      user.StatusText,
+		StatusExpiresAt: user.StatusExpiresAt,
+		LastSeen:        user.LastSeen,
+	}
+}
+
+// reportActivity records how long the user has been inactive on a device, or on
+// every local connection when deviceID is empty. A user the server set idle is
+// brought back online as soon as a device reports activity.
+func (h *Hub) reportActivity(userID, deviceID string, inactiveFor time.Duration) {
+	var since int64
+	if inactiveFor > 0 {
+		since = time.Now().Add(-inactiveFor).UnixNano()
+	}
+
+	becameActive := false
+
+	h.mu.RLock()
+	for client := range h.Clients[userID] {
+		if deviceID != "" && client.DeviceID != deviceID {
+			continue
+		}
+		if client.inactiveSince.Swap(since) != 0 && since == 0 {
+			becameActive = true
+		}
+	}
+	h.mu.RUnlock()
+
+	if becameActive {
+		// Other replicas must not set the user idle before the next activity report
+		if err := h.Presence.ReportActivity(context.Background(), map[string]bool{userID: true}); err != nil {
+			logPresenceError("report activity", err)
+		}
+		h.wakeFromIdle(userID)
+	}
+}
+
+// wakeFromIdle puts a user the server set idle back online and reports whether it did
+func (h *Hub) wakeFromIdle(userID string) bool {
+	tag, err := database.Pool.Exec(context.Background(), `
+		UPDATE users SET presence_status = 'online', presence_auto_idle = false
+		WHERE id = $1 AND presence_auto_idle
+	`, userID)
+
+	if err != nil {
+		log.Printf("Failed to restore presence for %s: %v", userID, err)
+		return false
+	}
+
+	if tag.RowsAffected() == 0 {
+		return false
+	}
+
+	h.presenceChanged(userID)
+	return true
+}
+
+// idleInactiveUsers sets users idle once every one of their connections, on every
+// replica, has reported inactivity for IdleAfter. Each replica shares which of its
+// users are active through the presence store and only idles users active nowhere.
+// Clients that never report activity keep their user online.
+func (h *Hub) idleInactiveUsers() {
+	cutoff := time.Now().Add(-h.IdleAfter).UnixNano()
+
+	activity := make(map[string]bool)
+	var inactive []string
+
+	h.mu.RLock()
+	for userID, connections := range h.Clients {
+		active := false
+		for client := range connections {
+			since := client.inactiveSince.Load()
+			if since == 0 || since > cutoff {
+				active = true
+				break
+			}
+		}
+		activity[userID] = active
+		if !active {
+			inactive = append(inactive, userID)
+		}
+	}
+	h.mu.RUnlock()
+
+	ctx := context.Background()
+	if err := h.Presence.ReportActivity(ctx, activity); err != nil {
+		logPresenceError("report activity", err)
+	}
+
+	if len(inactive) == 0 {
+		return
+	}
+
+	// Users still active on another replica stay online
+	activeElsewhere, err := h.Presence.ActiveUsers(ctx, inactive)
+	if err != nil {
+		logPresenceError("check activity", err)
+		return
+	}
+
+	inactive = slices.DeleteFunc(inactive, func(userID string) bool {
+		return activeElsewhere[userID]
+	})
+	if len(inactive) == 0 {
+		return
+	}
+
+	// Only users showing online go idle; away, dnd and invisible stay as chosen
+	h.updatePresences(`
+		UPDATE users SET presence_status = 'idle', presence_auto_idle = true
+		WHERE id = ANY($1::uuid[]) AND presence_status = 'online'
+		RETURNING id
+	`, inactive)
+}
+
+// expireStatuses reverts custom statuses past their expiry to online without text.
+// Each expired user is updated, and announced, by exactly one replica.
+func (h *Hub) expireStatuses() {
+	h.updatePresences(`
+		UPDATE users
+		SET presence_status = 'online', presence_auto_idle = false, status_text = NULL, status_expires_at = NULL
+		WHERE status_expires_at <= $1
+		RETURNING id
+	`, time.Now())
+}
+
+// updatePresences runs an update returning the IDs of users whose status changed and announces each change
+func (h *Hub) updatePresences(query string, args ...interface{}) {
+	rows, err := database.Pool.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Printf("Failed to update presence: %v", err)
+		return
+	}
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		h.presenceChanged(userID)
+	}
+}
@@ -0,0 +1,413 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/models"
+)
+
+// MaxPresenceSubscriptions is how many users one connection may follow the presence of
+const MaxPresenceSubscriptions = 500
+
+// presenceSubscriptions indexes the connections following each user's presence.
+// Contacts receive presence without subscribing, so the index only holds users
+// the client shares a chat or group with but no contact relationship.
+type presenceSubscriptions struct {
+	mu       sync.Mutex
+	byTarget map[string]map[*Client]bool // Followed user -> local connections following them
+	byClient map[*Client]map[string]bool // Connection -> users it follows
+
+	// Orders the broker calls for each followed user, made without holding mu
+	watchLocks *keyedMutex
+}
+
+// newPresenceSubscriptions creates an empty index
+func newPresenceSubscriptions() *presenceSubscriptions {
+	return &presenceSubscriptions{
+		byTarget:   make(map[string]map[*Client]bool),
+		byClient:   make(map[*Client]map[string]bool),
+		watchLocks: newKeyedMutex(),
+	}
+}
+
+// subscribePresence makes a connection follow the given users. The broker is
+// told to deliver presence changes of users this replica starts following.
+func (h *Hub) subscribePresence(client *Client, userIDs []string) *ErrorPayload {
+	watch, errPayload := h.indexPresenceSubscription(client, userIDs)
+	h.syncPresenceWatch(watch)
+	return errPayload
+}
+
+// indexPresenceSubscription adds a connection's subscriptions to the index and
+// returns the users this replica started following
+func (h *Hub) indexPresenceSubscription(client *Client, userIDs []string) ([]string, *ErrorPayload) {
+	subs := h.presenceSubs
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	// A connection that unregistered while the subscription was authorized would
+	// never be removed from the index. One still registered is removed by
+	// unregisterClient, which unsubscribes it after this lock is released.
+	if !h.isRegistered(client) {
+		return nil, &ErrorPayload{Code: "invalid_request", Message: "Connection is closed"}
+	}
+
+	following := subs.byClient[client]
+
+	added := 0
+	for _, userID := range userIDs {
+		if !following[userID] {
+			added++
+		}
+	}
+
+	if len(following)+added > MaxPresenceSubscriptions {
+		return nil, &ErrorPayload{Code: "invalid_request", Message: "Too many presence subscriptions"}
+	}
+
+	if following == nil {
+		following = make(map[string]bool)
+		subs.byClient[client] = following
+	}
+
+	var watch []string
+	for _, userID := range userIDs {
+		following[userID] = true
+
+		subscribers, ok := subs.byTarget[userID]
+		if !ok {
+			subscribers = make(map[*Client]bool)
+			subs.byTarget[userID] = subscribers
+			watch = append(watch, userID)
+		}
+		subscribers[client] = true
+	}
+
+	return watch, nil
+}
+
+// unsubscribePresence stops a connection following the given users, or every user when userIDs is nil
+func (h *Hub) unsubscribePresence(client *Client, userIDs []string) {
+	h.syncPresenceWatch(h.unindexPresenceSubscription(client, userIDs))
+}
+
+// unindexPresenceSubscription removes a connection's subscriptions from the index
+// and returns the users this replica stopped following
+func (h *Hub) unindexPresenceSubscription(client *Client, userIDs []string) []string {
+	subs := h.presenceSubs
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	following, ok := subs.byClient[client]
+	if !ok {
+		return nil
+	}
+
+	if userIDs == nil {
+		userIDs = make([]string, 0, len(following))
+		for userID := range following {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	var unwatch []string
+	for _, userID := range userIDs {
+		if !following[userID] {
+			continue
+		}
+		delete(following, userID)
+
+		subscribers := subs.byTarget[userID]
+		delete(subscribers, client)
+		if len(subscribers) > 0 {
+			continue
+		}
+		delete(subs.byTarget, userID)
+		unwatch = append(unwatch, userID)
+	}
+
+	if len(following) == 0 {
+		delete(subs.byClient, client)
+	}
+
+	return unwatch
+}
+
+// syncPresenceWatch watches the presence of each given user that is followed on
+// this replica and unwatches the rest. The broker is called without holding the
+// index lock; the per-user lock keeps its calls in the order the index changed,
+// since each call reflects the index as it is when the call is made.
+func (h *Hub) syncPresenceWatch(userIDs []string) {
+	watcher, ok := h.Broker.(PresenceWatcher)
+	if !ok || len(userIDs) == 0 {
+		return
+	}
+
+	subs := h.presenceSubs
+	unlock := subs.watchLocks.Lock(userIDs...)
+	defer unlock()
+
+	for _, userID := range userIDs {
+		subs.mu.Lock()
+		_, followed := subs.byTarget[userID]
+		subs.mu.Unlock()
+
+		if followed {
+			if err := watcher.WatchPresence(userID); err != nil {
+				log.Printf("Failed to watch presence of %s: %v", userID, err)
+			}
+		} else if err := watcher.UnwatchPresence(userID); err != nil {
+			log.Printf("Failed to unwatch presence of %s: %v", userID, err)
+		}
+	}
+}
+
+// publishPresence delivers a user's presence, as non-contacts see it, to the
+// connections following them on this replica and on the others
+func (h *Hub) publishPresence(userID string, message WSMessage) {
+	h.notifySubscribers(userID, message)
+
+	if h.Broker == nil {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	envelope := Envelope{
+		Origin:   h.NodeID,
+		Presence: userID,
+		Message:  data,
+	}
+
+	if err := h.Broker.Publish(context.Background(), envelope); err != nil {
+		log.Printf("Failed to publish presence of %s: %v", userID, err)
+	}
+}
+
+// notifySubscribers queues a presence event on the local connections following the user
+func (h *Hub) notifySubscribers(userID string, message WSMessage) {
+	subs := h.presenceSubs
+	subs.mu.Lock()
+	clients := make([]*Client, 0, len(subs.byTarget[userID]))
+	for client := range subs.byTarget[userID] {
+		clients = append(clients, client)
+	}
+	subs.mu.Unlock()
+
+	if len(clients) == 0 {
+		return
+	}
+
+	encoded := newEncodedMessage(message)
+	for _, client := range clients {
+		client.enqueueMessage(encoded, true)
+	}
+}
+
+// presenceView is a user's presence and how they relate to the viewer
+type presenceView struct {
+	user      *models.User
+	isContact bool // The viewer is in the user's contact list
+	related   bool // Either user has the other as a contact, so presence already reaches the viewer
+}
+
+// loadPresenceViews reads the presence and privacy settings of several users as one viewer sees them
+func (h *Hub) loadPresenceViews(viewerID string, userIDs []string) ([]presenceView, error) {
+	online, err := h.OnlineUsers(userIDs)
+	if err != nil {
+		logPresenceError("check online status", err)
+		online = map[string]bool{}
+	}
+
+	rows, err := database.Pool.Query(context.Background(), `
+		SELECT u.id, u.presence_status, u.status_text, u.status_expires_at, u.last_seen,
+			u.privacy_last_seen, u.privacy_online,
+			EXISTS(SELECT 1 FROM contacts WHERE user_id = u.id AND contact_id = $2),
+			EXISTS(SELECT 1 FROM contacts WHERE user_id = $2 AND contact_id = u.id)
+		FROM users u
+		WHERE u.id = ANY($1::uuid[])
+	`, userIDs, viewerID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := make([]presenceView, 0, len(userIDs))
+	for rows.Next() {
+		user := &models.User{}
+		var isContact, hasContact bool
+		if err := rows.Scan(&user.ID, &user.PresenceStatus, &user.StatusText, &user.StatusExpiresAt, &user.LastSeen,
+			&user.Privacy.LastSeen, &user.Privacy.Online, &isContact, &hasContact); err != nil {
+			return nil, err
+		}
+
+		user.IsOnline = online[user.ID]
+		views = append(views, presenceView{
+			user:      user,
+			isContact: isContact,
+			related:   isContact || hasContact,
+		})
+	}
+
+	return views, rows.Err()
+}
+
+// handleSubscribePresence follows the presence of users shown in an open chat or
+// group who are not contacts, and replies with their current presence
+func (c *Client) handleSubscribePresence(payload map[string]interface{}) {
+	var frame PresenceSubscriptionFrame
+	if err := decodePayload(payload, &frame); err != nil || len(frame.UserIDs) == 0 {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "userIds is required"})
+		return
+	}
+
+	if len(frame.UserIDs) > MaxPresenceSubscriptions {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Too many presence subscriptions"})
+		return
+	}
+
+	subscriber := c.subscriber()
+	if subscriber == nil {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "deviceId must name an open event stream"})
+		return
+	}
+
+	userIDs := uniqueIDs(frame.UserIDs, c.ID)
+
+	allowed, err := c.Hub.Authorizer.CanFollowPresence(context.Background(), c.ID, userIDs)
+	if err != nil {
+		c.sendError(&ErrorPayload{Code: "internal_error", Message: "Failed to authorize event"})
+		return
+	}
+
+	result := PresenceSubscribedPayload{
+		UserIDs:  []string{},
+		Denied:   []string{},
+		Presence: []PresencePayload{},
+	}
+
+	var permitted []string
+	for _, userID := range userIDs {
+		if allowed[userID] {
+			permitted = append(permitted, userID)
+		} else {
+			result.Denied = append(result.Denied, userID)
+		}
+	}
+
+	if len(permitted) > 0 {
+		views, err := c.Hub.loadPresenceViews(c.ID, permitted)
+		if err != nil {
+			log.Printf("Failed to load presence for subscription: %v", err)
+			c.sendError(&ErrorPayload{Code: "internal_error", Message: "Failed to load presence"})
+			return
+		}
+
+		// Contacts already receive presence changes, so only the rest are indexed
+		var follow []string
+		for _, view := range views {
+			if !view.related {
+				follow = append(follow, view.user.ID)
+			}
+
+			response := view.user.ToResponse(view.isContact)
+
+			result.UserIDs = append(result.UserIDs, view.user.ID)
+			result.Presence = append(result.Presence, presencePayload(response))
+		}
+
+		if errPayload := c.Hub.subscribePresence(subscriber, follow); errPayload != nil {
+			c.sendError(errPayload)
+			return
+		}
+	}
+
+	c.reply(WSMessage{
+		Type:      EventPresenceSubscribed,
+		Payload:   result,
+		Timestamp: time.Now(),
+	})
+}
+
+// handleUnsubscribePresence stops following the given users, or everyone when userIds is empty
+func (c *Client) handleUnsubscribePresence(payload map[string]interface{}) {
+	var frame PresenceSubscriptionFrame
+	if err := decodePayload(payload, &frame); err != nil {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "Invalid presence subscription payload"})
+		return
+	}
+
+	subscriber := c.subscriber()
+	if subscriber == nil {
+		c.sendError(&ErrorPayload{Code: "invalid_request", Message: "deviceId must name an open event stream"})
+		return
+	}
+
+	if len(frame.UserIDs) == 0 {
+		frame.UserIDs = nil
+	}
+
+	c.Hub.unsubscribePresence(subscriber, frame.UserIDs)
+}
+
+// subscriber returns the connection presence subscriptions belong to. Events sent
+// over REST subscribe the SSE or long-poll client registered with their device ID.
+func (c *Client) subscriber() *Client {
+	if c.replies == nil {
+		return c
+	}
+
+	if c.DeviceID == "" {
+		return nil
+	}
+
+	return c.Hub.localClient(c.ID, c.DeviceID)
+}
+
+// localClient returns a user's connection to this replica from the given device
+func (h *Hub) localClient(userID, deviceID string) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.Clients[userID] {
+		if client.DeviceID == deviceID {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// isRegistered reports whether a connection is registered with this replica
+func (h *Hub) isRegistered(client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.Clients[client.ID][client]
+}
+
+// uniqueIDs removes duplicates and the excluded ID from a list of IDs, keeping their order
+func uniqueIDs(ids []string, exclude string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		if id == exclude || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+
+	return unique
+}
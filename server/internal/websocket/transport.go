@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Transport identifies how a client receives events
+type Transport string
+
+const (
+	// TransportWebSocket delivers frames over a WebSocket connection
+	TransportWebSocket Transport = "websocket"
+
+	// TransportSSE streams frames as Server-Sent Events
+	TransportSSE Transport = "sse"
+
+	// TransportLongPoll returns queued frames to repeated poll requests
+	TransportLongPoll Transport = "longpoll"
+)
+
+// NewStreamClient creates a client for a transport without a WebSocket connection.
+// Frames are always JSON; the caller reads them with WaitFrames.
+func NewStreamClient(userID, uniqueID, deviceID string, transport Transport, hub *Hub) *Client {
+	return &Client{
+		ID:        userID,
+		UniqueID:  uniqueID,
+		DeviceID:  deviceID,
+		Hub:       hub,
+		Transport: transport,
+		outbox:    newOutbox(hub.SendQueueSize, hub.DropPolicy, &hub.Metrics),
+		Encoding:  EncodingJSON,
+		Since:     -1,
+		ready:     make(chan struct{}),
+	}
+}
+
+// WaitFrames returns the frames queued for the client, waiting up to timeout for
+// the first one. Replayed events come before live ones. closed is true once the
+// hub has closed the client, after which no more frames arrive.
+func (c *Client) WaitFrames(timeout time.Duration) (frames [][]byte, closed bool) {
+	<-c.ready
+	if len(c.pending) > 0 {
+		frames = c.pending
+		c.pending = nil
+		return frames, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		queued, closed, _, _ := c.outbox.drain()
+		if len(queued) > 0 || closed {
+			for _, frame := range queued {
+				frames = append(frames, frame.data)
+			}
+			return frames, closed
+		}
+
+		select {
+		case <-c.outbox.notify:
+		case <-timer.C:
+			return nil, false
+		}
+	}
+}
+
+// FrameSeq returns the event sequence of an encoded JSON frame (0 for ephemeral events)
+func FrameSeq(frame []byte) int64 {
+	var header struct {
+		Seq int64 `json:"seq"`
+	}
+	json.Unmarshal(frame, &header)
+	return header.Seq
+}
+
+// HandleEvent processes an event a user sent without a socket (over REST) exactly
+// like one received on a connection, and returns the ack or error frames it produced.
+// deviceID names the user's SSE or long-poll client for events tied to a connection.
+func (h *Hub) HandleEvent(userID, name, deviceID string, msg IncomingMessage) []WSMessage {
+	replies := []WSMessage{}
+
+	client := &Client{
+		ID:       userID,
+		Name:     name,
+		DeviceID: deviceID,
+		Hub:      h,
+		replies:  &replies,
+	}
+	client.handleIncomingMessage(msg)
+
+	return replies
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"ngabarin/server/internal/database"
+	"ngabarin/server/internal/mailer"
 	"ngabarin/server/internal/routes"
+	"ngabarin/server/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -19,12 +25,30 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// Load the keys tokens are signed and verified with
+	if err := utils.InitJWT(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Set up the mailer for verification and password reset emails
+	if err := mailer.Init(); err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+
 	// Connect to database
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Close()
 
+	// Connect to Redis when configured (used by the WebSocket hub across replicas)
+	if os.Getenv("REDIS_URL") != "" {
+		if err := database.ConnectRedis(); err != nil {
+			log.Fatalf("Failed to connect to redis: %v", err)
+		}
+		defer database.CloseRedis()
+	}
+
 	// Initialize WebSocket hub
 	routes.InitWebSocket()
 
@@ -49,8 +73,45 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("🚀 Server starting on port %s", port)
-	if err := app.Listen(":" + port); err != nil {
-		log.Fatal(err)
+	// Serve in the background so the server can drain on SIGINT/SIGTERM
+	go func() {
+		log.Printf("🚀 Server starting on port %s", port)
+		if err := app.Listen(":" + port); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("🛑 Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	// Stop accepting connections and wait for in-flight requests
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- app.ShutdownWithContext(ctx)
+	}()
+
+	// Close WebSocket, SSE and long-poll clients, which would otherwise keep requests open
+	if err := routes.ShutdownWebSocket(ctx); err != nil {
+		log.Printf("WebSocket clients did not drain in time: %v", err)
+	}
+
+	if err := <-stopped; err != nil {
+		log.Printf("Requests did not finish in time: %v", err)
+	}
+
+	log.Println("✅ Server stopped")
+}
+
+// shutdownTimeout returns how long shutdown waits for connections to drain
+func shutdownTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
 	}
+	return 30 * time.Second
 }
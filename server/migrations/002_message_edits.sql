@@ -0,0 +1,13 @@
+-- Track when a message was last edited
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE;
+
+-- Message edits table (previous revisions of edited messages)
+CREATE TABLE message_edits (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    edited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    previous_content TEXT NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id, edited_at DESC);
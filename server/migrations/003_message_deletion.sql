@@ -0,0 +1,12 @@
+-- Track when a message was deleted for everyone (content is cleared)
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Hidden messages table (messages deleted only for a single user)
+CREATE TABLE hidden_messages (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hidden_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_hidden_messages_user_id ON hidden_messages(user_id);
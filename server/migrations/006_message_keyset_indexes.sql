@@ -0,0 +1,3 @@
+-- Indexes for keyset pagination on (created_at, id)
+CREATE INDEX idx_messages_dm_created_at_id ON messages(sender_id, receiver_id, created_at DESC, id DESC);
+CREATE INDEX idx_messages_group_created_at_id ON messages(group_id, created_at DESC, id DESC);
@@ -0,0 +1,7 @@
+-- Full-text search over text message content
+ALTER TABLE messages ADD COLUMN content_tsv tsvector
+    GENERATED ALWAYS AS (
+        CASE WHEN type = 'text' THEN to_tsvector('simple', content) ELSE NULL END
+    ) STORED;
+
+CREATE INDEX idx_messages_content_tsv ON messages USING GIN (content_tsv);
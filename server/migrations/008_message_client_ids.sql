@@ -0,0 +1,6 @@
+-- Client-generated message IDs used to deduplicate retried sends
+ALTER TABLE messages ADD COLUMN client_message_id VARCHAR(100);
+
+CREATE UNIQUE INDEX idx_messages_sender_client_message_id
+    ON messages(sender_id, client_message_id)
+    WHERE client_message_id IS NOT NULL;
@@ -0,0 +1,17 @@
+-- Per-user event sequence counters
+CREATE TABLE user_event_sequences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL DEFAULT 0
+);
+
+-- Bounded log of WebSocket events, replayed to clients that reconnect with ?since=<seq>
+CREATE TABLE user_events (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX idx_user_events_created_at ON user_events(created_at);
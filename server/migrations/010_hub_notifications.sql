@@ -0,0 +1,8 @@
+-- Hub events too large for a NOTIFY payload, fetched by ID by every replica
+CREATE TABLE hub_notifications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_hub_notifications_created_at ON hub_notifications(created_at);
@@ -0,0 +1,10 @@
+-- Users who blocked other users (no DMs or typing events between them)
+CREATE TABLE user_blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id != blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks(blocked_id);
@@ -0,0 +1,10 @@
+-- Presence status chosen by the user, with an optional custom status that expires
+ALTER TABLE users
+    ADD COLUMN presence_status VARCHAR(20) NOT NULL DEFAULT 'online'
+        CHECK (presence_status IN ('online', 'idle', 'away', 'dnd', 'invisible')),
+    ADD COLUMN presence_auto_idle BOOLEAN NOT NULL DEFAULT false, -- Set idle by the server, not the user
+    ADD COLUMN status_text VARCHAR(100),
+    ADD COLUMN status_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_status_expires_at ON users(status_expires_at)
+    WHERE status_expires_at IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Who may see each user's last seen time, online status, avatar and read receipts
+ALTER TABLE users
+    ADD COLUMN privacy_last_seen VARCHAR(20) NOT NULL DEFAULT 'everyone'
+        CHECK (privacy_last_seen IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN privacy_online VARCHAR(20) NOT NULL DEFAULT 'everyone'
+        CHECK (privacy_online IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN privacy_avatar VARCHAR(20) NOT NULL DEFAULT 'everyone'
+        CHECK (privacy_avatar IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN privacy_read_receipts VARCHAR(20) NOT NULL DEFAULT 'everyone'
+        CHECK (privacy_read_receipts IN ('everyone', 'contacts', 'nobody'));
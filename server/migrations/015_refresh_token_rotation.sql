@@ -0,0 +1,25 @@
+-- Refresh tokens issued for each session. Every refresh marks the presented token
+-- used and issues the next one in the session's family; presenting a used token
+-- again revokes the session.
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+
+-- Security alerts shown to each user (e.g. a stolen refresh token being replayed)
+CREATE TABLE security_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    session_id UUID REFERENCES sessions(id) ON DELETE SET NULL,
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_security_events_user_id ON security_events(user_id, created_at DESC);
@@ -0,0 +1,22 @@
+-- TOTP two-factor authentication. totp_pending_secret holds a secret being enrolled
+-- until the user confirms it with a code; totp_last_step stops a code being used twice.
+-- totp_failed_attempts counts codes entered since the last correct one and
+-- totp_locked_until refuses further codes after too many.
+ALTER TABLE users
+    ADD COLUMN totp_secret TEXT,
+    ADD COLUMN totp_pending_secret TEXT,
+    ADD COLUMN totp_enabled_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN totp_last_step BIGINT,
+    ADD COLUMN totp_failed_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN totp_locked_until TIMESTAMP WITH TIME ZONE;
+
+-- One-time recovery codes, stored as bcrypt hashes
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id) WHERE used_at IS NULL;
@@ -0,0 +1,19 @@
+-- Verified email addresses. Accounts that existed before verification was required,
+-- and Google accounts, count as verified.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE users SET email_verified_at = created_at;
+
+-- Single-use tokens emailed to users (email verification, password reset).
+-- Only a SHA-256 hash of each token is stored.
+CREATE TABLE user_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id, purpose);